@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// batchRequest is the body of POST /departures/batch.
+type batchRequest struct {
+	StopIDs []string `json:"stopIds"`
+}
+
+// parseBatchRequest decodes and validates a batch body of at most maxBody
+// bytes. Duplicate stop IDs are collapsed and at most maxStops distinct
+// stops are accepted.
+func parseBatchRequest(w http.ResponseWriter, r *http.Request, maxStops int, maxBody int64) ([]string, *requestError) {
+	var req batchRequest
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBody))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return nil, &requestError{Status: http.StatusRequestEntityTooLarge, Code: "invalid_body", Message: "request body is too large"}
+		}
+		return nil, &requestError{Status: http.StatusBadRequest, Code: "invalid_body", Message: "body must be a JSON object like {\"stopIds\":[\"...\"]}"}
+	}
+
+	var stopIDs []string
+	seen := make(map[string]bool)
+	for _, id := range req.StopIDs {
+		id = strings.TrimSpace(id)
+		if id == "" || seen[id] {
+			continue
+		}
+		seen[id] = true
+		stopIDs = append(stopIDs, id)
+	}
+	if len(stopIDs) == 0 {
+		return nil, badParameter("stopIds must contain at least one stop ID")
+	}
+	if len(stopIDs) > maxStops {
+		return nil, badParameter("at most %d stops may be requested per batch", maxStops)
+	}
+	return stopIDs, nil
+}
+
+// fetchBatch fetches the departures of all stops concurrently, at most
+// Config.BatchConcurrency at a time, each through the cache like a single
+// /next-departures request. The result maps every stop ID to either its
+// departures response or an error body, so one failing stop does not fail
+// the others.
+func fetchBatch(ctx context.Context, cache Cache, client *RMVClient, config Config, q boardQuery, stopIDs []string) map[string]any {
+	var (
+		mu      sync.Mutex
+		g       errgroup.Group
+		results = make(map[string]any, len(stopIDs))
+	)
+	g.SetLimit(config.BatchConcurrency)
+	set := func(stopID string, v any) {
+		mu.Lock()
+		defer mu.Unlock()
+		results[stopID] = v
+	}
+
+	for _, stopID := range stopIDs {
+		if !config.stopAllowed(stopID) {
+			set(stopID, errorBody{Error: errorDetail{Code: "stop_not_allowed", Message: fmt.Sprintf("stop %s is not allowed", stopID)}})
+			continue
+		}
+		g.Go(func() error {
+			result, err := fetchBoardMaxAge(ctx, cache, client, config, stopID, q.Duration, q.Lang, BoardDepartures, q.maxAge(config))
+			if err != nil {
+				_, detail := fetchError(ctx, err, BoardDepartures.resource(), "stopId", stopID)
+				set(stopID, errorBody{Error: detail})
+				return nil
+			}
+			set(stopID, q.View.Transform(BoardDepartures, stopID, q.apply(result.Board, config.Location, time.Now())))
+			return nil
+		})
+	}
+	g.Wait()
+	return results
+}
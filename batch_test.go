@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// postBatch posts body to /departures/batch and decodes the JSON response
+// into dst.
+func postBatch(t *testing.T, serverURL, body string, dst any) *http.Response {
+	t.Helper()
+	resp, err := http.Post(serverURL+"/departures/batch", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("POST /departures/batch: %v", err)
+	}
+	defer resp.Body.Close()
+	if dst != nil {
+		if err := json.NewDecoder(resp.Body).Decode(dst); err != nil {
+			t.Fatalf("POST /departures/batch: decoding response: %v", err)
+		}
+	}
+	return resp
+}
+
+func TestBatchRejectsOversizedBody(t *testing.T) {
+	stub := newStubRMV(t, serveBoard(5*time.Minute))
+	config := testConfig(t, stub.URL, "BATCH_MAX_BODY_BYTES", "1024")
+	server := newTestServer(t, config, stub.client())
+
+	ids := strings.Repeat(`"3000001",`, 200)
+	var body errorBody
+	resp := postBatch(t, server.URL, `{"stopIds":[`+ids+`"3000002"]}`, &body)
+	if resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Errorf("got status %d, want 413", resp.StatusCode)
+	}
+	if body.Error.Code != "invalid_body" {
+		t.Errorf("got code %q, want invalid_body", body.Error.Code)
+	}
+	if n := stub.calls.Load(); n != 0 {
+		t.Errorf("upstream got %d calls for a rejected batch, want 0", n)
+	}
+}
+
+func TestBatchConcurrencyIsBounded(t *testing.T) {
+	var tracker peakTracker
+	stub := newStubRMV(t, tracker.serve(20*time.Millisecond, serveBoard(5*time.Minute)))
+	config := testConfig(t, stub.URL, "BATCH_CONCURRENCY", "2")
+	server := newTestServer(t, config, stub.client())
+
+	var results map[string]json.RawMessage
+	body := `{"stopIds":["3000001","3000002","3000003","3000004","3000005","3000006","3000007","3000008"]}`
+	if resp := postBatch(t, server.URL, body, &results); resp.StatusCode != http.StatusOK {
+		t.Fatalf("got status %d, want 200", resp.StatusCode)
+	}
+	if len(results) != 8 {
+		t.Errorf("got results for %d stops, want 8", len(results))
+	}
+	if n := stub.calls.Load(); n != 8 {
+		t.Errorf("upstream got %d calls, want 8", n)
+	}
+	if p := tracker.peak(); p != 2 {
+		t.Errorf("got at most %d upstream calls in flight, want 2", p)
+	}
+}
+
+func TestBatchMergesDuplicateStops(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/departures/batch",
+		strings.NewReader(`{"stopIds":["3000001"," 3000001 ","3000002","3000001",""]}`))
+	stopIDs, reqErr := parseBatchRequest(httptest.NewRecorder(), req, 2, 1024)
+	if reqErr != nil {
+		t.Fatalf("duplicates counted against the limit of 2 stops: %s", reqErr.Message)
+	}
+	if !slices.Equal(stopIDs, []string{"3000001", "3000002"}) {
+		t.Errorf("got stop IDs %q, want [3000001 3000002]", stopIDs)
+	}
+
+	stub := newStubRMV(t, serveBoard(5*time.Minute))
+	server := newTestServer(t, testConfig(t, stub.URL), stub.client())
+	var results map[string]json.RawMessage
+	postBatch(t, server.URL, `{"stopIds":["3000001","3000001","3000002","3000001"]}`, &results)
+	if len(results) != 2 {
+		t.Errorf("got results for %d stops, want 2", len(results))
+	}
+	if n := stub.calls.Load(); n != 2 {
+		t.Errorf("upstream got %d calls, want one per distinct stop", n)
+	}
+}
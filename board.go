@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// boardResult is a board together with how it was obtained.
+type boardResult struct {
+	Board *DepartureBoard
+	// Hit reports whether the board was served from cache.
+	Hit bool
+	// Stale reports whether expired data was served because the upstream
+	// refresh failed, RateLimited whether it failed for a rate limit.
+	Stale       bool
+	RateLimited bool
+	StoredAt    time.Time
+	ExpiresAt   time.Time
+}
+
+// Age returns how old the served data is.
+func (r boardResult) Age() time.Duration {
+	return time.Since(r.StoredAt)
+}
+
+// minPollAfter is the shortest polling interval PollAfterSeconds suggests,
+// so clients do not hammer the service while stale data is served.
+const minPollAfter = 5 * time.Second
+
+// PollAfterSeconds is how long a client should wait before asking for the
+// board again, sent as the Refresh header: until the served entry expires,
+// but at least minPollAfter.
+func (r boardResult) PollAfterSeconds() int {
+	return int(max(minPollAfter, time.Until(r.ExpiresAt)+time.Second-1).Seconds())
+}
+
+// fetchBoard returns the board of the given type for a stop, serving it from
+// cache when possible. Departures and arrivals use separate cache keys.
+//
+// If the upstream refresh fails or runs out of time, an expired entry within
+// the cache's stale window is served instead; an error is only returned when
+// there is none or the caller went away.
+func fetchBoard(ctx context.Context, cache Cache, client *RMVClient, config Config, stopID string, duration int, lang string, boardType BoardType) (boardResult, error) {
+	return fetchBoardMaxAge(ctx, cache, client, config, stopID, duration, lang, boardType, 0)
+}
+
+// fetchBoardMaxAge is fetchBoard, but when maxAge is positive a cached board
+// older than maxAge is refreshed even if it has not expired yet.
+func fetchBoardMaxAge(ctx context.Context, cache Cache, client *RMVClient, config Config, stopID string, duration int, lang string, boardType BoardType, maxAge time.Duration) (boardResult, error) {
+	cacheKey := boardCacheKey(boardType, stopID, duration, lang)
+	if item, ok := cache.Get(ctx, cacheKey); ok && (maxAge <= 0 || time.Since(item.StoredAt) < maxAge) {
+		if board, ok := decodeCachedBoard(ctx, item); ok {
+			cacheRequestsTotal.WithLabelValues("hit").Inc()
+			traceBoardLookup(ctx, stopID, "hit")
+			slog.InfoContext(ctx, "cache hit", "board", boardType, "stopId", stopID, "duration", duration)
+			return boardResult{Board: board, Hit: true, StoredAt: item.StoredAt, ExpiresAt: item.ExpiresAt}, nil
+		}
+	}
+	cacheRequestsTotal.WithLabelValues("miss").Inc()
+	traceBoardLookup(ctx, stopID, "miss")
+
+	// Only a request with a stale entry to fall back on is failed fast by
+	// the rate limiter; a cold miss waits for its turn.
+	stale, hasStale := cache.GetStale(ctx, cacheKey)
+	fetchCtx := ctx
+	if hasStale {
+		fetchCtx = withStaleFallback(ctx)
+	}
+	result, err := refreshBoard(fetchCtx, cache, client, config, stopID, duration, lang, boardType)
+	if err != nil && hasStale && !errors.Is(err, context.Canceled) {
+		if board, ok := decodeCachedBoard(ctx, stale); ok {
+			cacheRequestsTotal.WithLabelValues("stale").Inc()
+			traceBoardLookup(ctx, stopID, "stale")
+			slog.WarnContext(ctx, "upstream failed, serving stale data", "board", boardType, "stopId", stopID, "age", time.Since(stale.StoredAt), "error", err)
+			return boardResult{Board: board, Hit: true, Stale: true, RateLimited: rateLimited(err), StoredAt: stale.StoredAt, ExpiresAt: stale.ExpiresAt}, nil
+		}
+	}
+	return result, err
+}
+
+// boardFetches collapses concurrent upstream fetches of the same board into one.
+var boardFetches singleflight.Group
+
+// coalescedFetches counts refreshBoard calls that were served by another
+// caller's in-flight fetch instead of making their own.
+var coalescedFetches atomic.Uint64
+
+// refreshBoard fetches the board from upstream and stores it in the cache,
+// regardless of whether a cached entry exists. Concurrent calls for the same
+// board share a single upstream request.
+func refreshBoard(ctx context.Context, cache Cache, client *RMVClient, config Config, stopID string, duration int, lang string, boardType BoardType) (boardResult, error) {
+	// leader is only set for the caller whose fn runs; ch is sent to after
+	// fn returns, so reading it once res arrives is race-free.
+	var leader bool
+	ch := boardFetches.DoChan(boardCacheKey(boardType, stopID, duration, lang), func() (any, error) {
+		leader = true
+		// Detach from the first caller so its disconnect doesn't fail
+		// everyone else waiting on the same fetch, but keep its deadline
+		// so retries cannot outlast the request.
+		fetchCtx := context.WithoutCancel(ctx)
+		if deadline, ok := ctx.Deadline(); ok {
+			var cancel context.CancelFunc
+			fetchCtx, cancel = context.WithDeadline(fetchCtx, deadline)
+			defer cancel()
+		}
+		return updateBoard(fetchCtx, cache, client, config, stopID, duration, lang, boardType)
+	})
+	select {
+	case <-ctx.Done():
+		return boardResult{}, ctx.Err()
+	case res := <-ch:
+		if !leader {
+			coalescedFetches.Add(1)
+			coalescedRequestsTotal.Inc()
+		}
+		if res.Err != nil {
+			return boardResult{}, res.Err
+		}
+		return res.Val.(boardResult), nil
+	}
+}
+
+// updateBoard performs the upstream fetch and stores the result in the cache.
+func updateBoard(ctx context.Context, cache Cache, client *RMVClient, config Config, stopID string, duration int, lang string, boardType BoardType) (boardResult, error) {
+	board, err := client.Board(ctx, boardType, stopID, duration, lang)
+	if err != nil {
+		return boardResult{}, err
+	}
+
+	now := time.Now()
+	ttl := config.boardTTL(boardType, stopID)
+	if data, err := json.Marshal(board); err != nil {
+		slog.ErrorContext(ctx, "failed to encode board for cache", "error", err)
+	} else {
+		cache.Set(ctx, boardCacheKey(boardType, stopID, duration, lang), data, ttl)
+	}
+	slog.InfoContext(ctx, "fetched new data", "board", boardType, "stopId", stopID, "duration", duration, "lang", lang)
+
+	result := boardResult{Board: board, StoredAt: now, ExpiresAt: now.Add(ttl)}
+	boardUpdates.Publish(boardCacheKey(boardType, stopID, duration, lang), result)
+	return result, nil
+}
+
+// decodeCachedBoard decodes a board stored by updateBoard. Entries that fail
+// to decode, e.g. written by an incompatible version sharing a Redis cache,
+// are treated as a miss.
+func decodeCachedBoard(ctx context.Context, item CacheItem) (*DepartureBoard, bool) {
+	var board DepartureBoard
+	if err := json.Unmarshal(item.Data, &board); err != nil {
+		slog.WarnContext(ctx, "ignoring undecodable cache entry", "error", err)
+		return nil, false
+	}
+	return &board, true
+}
+
+// warmCache fetches the departures of the stops in Config.keptStops into
+// the cache. Failures are logged and otherwise ignored, so a transient
+// upstream problem never prevents startup.
+func warmCache(ctx context.Context, cache Cache, client *RMVClient, config Config) {
+	stopIDs := config.keptStops()
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for _, stopID := range stopIDs {
+		wg.Go(func() {
+			if _, err := refreshBoard(ctx, cache, client, config, stopID, defaultDuration, defaultLang, BoardDepartures); err != nil {
+				slog.WarnContext(ctx, "cache warm-up failed", "stopId", stopID, "error", err)
+			}
+		})
+	}
+	wg.Wait()
+	slog.InfoContext(ctx, "cache warm-up finished", "stops", len(stopIDs), "duration", time.Since(start))
+}
+
+// boardCacheKey identifies a board in the cache. It starts with the board
+// type and stop ID, so POST /cache/purge can drop a stop by prefix.
+func boardCacheKey(boardType BoardType, stopID string, duration int, lang string) string {
+	return string(boardType) + ":" + stopID + ":" + strconv.Itoa(duration) + ":" + lang
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+func TestFetchBoardServesStaleOnUpstream429(t *testing.T) {
+	stub := newStubRMV(t, serveBoard(5*time.Minute))
+	config := testConfig(t, stub.URL, "CACHE_TTL", "1m")
+	cache := NewMemoryCache(0, time.Hour)
+	client := stub.client()
+	ctx := context.Background()
+
+	if _, err := fetchBoard(ctx, cache, client, config, "ratelimited-429", defaultDuration, defaultLang, BoardDepartures); err != nil {
+		t.Fatalf("first fetch: %v", err)
+	}
+	advance(cache, 2*time.Minute)
+	stub.setHandler(serveBody(http.StatusTooManyRequests, nil))
+
+	result, err := fetchBoard(ctx, cache, client, config, "ratelimited-429", defaultDuration, defaultLang, BoardDepartures)
+	if err != nil {
+		t.Fatalf("fetch after 429: %v", err)
+	}
+	if !result.Stale || !result.RateLimited {
+		t.Errorf("got stale=%v rateLimited=%v, want both", result.Stale, result.RateLimited)
+	}
+	if n := len(result.Board.Departures); n != 1 {
+		t.Errorf("got %d departures, want the cached 1", n)
+	}
+
+	_, err = fetchBoard(ctx, cache, client, config, "ratelimited-uncached", defaultDuration, defaultLang, BoardDepartures)
+	if !rateLimited(err) {
+		t.Errorf("uncached fetch: got %v, want a rate limit error", err)
+	}
+}
+
+func TestFetchBoardServesStaleWhenLimiterDenies(t *testing.T) {
+	stub := newStubRMV(t, serveBoard(5*time.Minute))
+	config := testConfig(t, stub.URL, "CACHE_TTL", "1m")
+	cache := NewMemoryCache(0, time.Hour)
+	client := stub.client()
+	// The next token comes within the deadline, but later than a request
+	// with stale data may wait.
+	client.Limiter = rate.NewLimiter(rate.Every(3*time.Second), 1)
+
+	if _, err := fetchBoard(context.Background(), cache, client, config, "ratelimited-limiter", defaultDuration, defaultLang, BoardDepartures); err != nil {
+		t.Fatalf("first fetch: %v", err)
+	}
+	advance(cache, 2*time.Minute)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	start := time.Now()
+	result, err := fetchBoard(ctx, cache, client, config, "ratelimited-limiter", defaultDuration, defaultLang, BoardDepartures)
+	if err != nil {
+		t.Fatalf("fetch with drained limiter: %v", err)
+	}
+	if !result.Stale || !result.RateLimited {
+		t.Errorf("got stale=%v rateLimited=%v, want both", result.Stale, result.RateLimited)
+	}
+	if elapsed := time.Since(start); elapsed > limiterMaxWait {
+		t.Errorf("took %v, want no wait for the limiter", elapsed)
+	}
+	if n := stub.calls.Load(); n != 1 {
+		t.Errorf("upstream got %d calls, want 1", n)
+	}
+}
+
+// serveHanging answers a request only once it is cancelled or the test ends.
+func serveHanging(t *testing.T) http.HandlerFunc {
+	done := make(chan struct{})
+	t.Cleanup(func() { close(done) })
+	return func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-done:
+		}
+	}
+}
+
+func TestFetchBoardServesStaleWhenUpstreamHangs(t *testing.T) {
+	stub := newStubRMV(t, serveBoard(5*time.Minute))
+	config := testConfig(t, stub.URL, "CACHE_TTL", "1m")
+	cache := NewMemoryCache(0, time.Hour)
+	client := stub.client()
+	// Retries that would outlast the request on their own.
+	client.Retry = RetryPolicy{MaxAttempts: 3, BaseBackoff: 10 * time.Millisecond}
+	client.Timeout = 10 * time.Second
+
+	if _, err := fetchBoard(context.Background(), cache, client, config, "hanging", defaultDuration, defaultLang, BoardDepartures); err != nil {
+		t.Fatalf("first fetch: %v", err)
+	}
+	advance(cache, 2*time.Minute)
+	stub.setHandler(serveHanging(t))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	result, err := fetchBoard(ctx, cache, client, config, "hanging", defaultDuration, defaultLang, BoardDepartures)
+	if err != nil {
+		t.Fatalf("fetch from hanging upstream: %v", err)
+	}
+	if !result.Stale {
+		t.Error("got a fresh result, want stale")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("took %v, want about the 200ms request deadline", elapsed)
+	}
+}
+
+func TestFetchBoardSkipsStaleWhenCallerCancels(t *testing.T) {
+	stub := newStubRMV(t, serveBoard(5*time.Minute))
+	config := testConfig(t, stub.URL, "CACHE_TTL", "1m")
+	cache := NewMemoryCache(0, time.Hour)
+	client := stub.client()
+
+	if _, err := fetchBoard(context.Background(), cache, client, config, "cancelled", defaultDuration, defaultLang, BoardDepartures); err != nil {
+		t.Fatalf("first fetch: %v", err)
+	}
+	advance(cache, 2*time.Minute)
+	stub.setHandler(serveHanging(t))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+	if _, err := fetchBoard(ctx, cache, client, config, "cancelled", defaultDuration, defaultLang, BoardDepartures); !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v, want context.Canceled", err)
+	}
+}
+
+// Run with -race to also check the shared result handling.
+func TestFetchBoardCoalescesConcurrentMisses(t *testing.T) {
+	release := make(chan struct{})
+	stub := newStubRMV(t, func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		serveBoard(5*time.Minute)(w, r)
+	})
+	config := testConfig(t, stub.URL)
+	cache := NewMemoryCache(0, time.Hour)
+	client := stub.client()
+
+	const n = 20
+	errs := make(chan error, n)
+	for range n {
+		go func() {
+			_, err := fetchBoard(context.Background(), cache, client, config, "coalesced", defaultDuration, defaultLang, BoardDepartures)
+			errs <- err
+		}()
+	}
+	// Hold the first upstream call until the others had time to pile up;
+	// any that arrive later are served from cache instead.
+	for stub.calls.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+
+	for range n {
+		if err := <-errs; err != nil {
+			t.Errorf("fetch: %v", err)
+		}
+	}
+	if got := stub.calls.Load(); got != 1 {
+		t.Errorf("upstream got %d calls for %d concurrent misses, want 1", got, n)
+	}
+}
+
+func TestFetchBoardColdMissWaitsForLimiter(t *testing.T) {
+	stub := newStubRMV(t, serveBoard(5*time.Minute))
+	client := stub.client()
+	client.Limiter = rate.NewLimiter(rate.Every(1500*time.Millisecond), 1)
+	client.Limiter.Allow()
+	server := newTestServer(t, testConfig(t, stub.URL), client)
+
+	start := time.Now()
+	resp := getJSON(t, server.URL+"/next-departures?stopId=3000001", nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("got status %d, want 200 once the limiter has a token", resp.StatusCode)
+	}
+	if got := resp.Header.Get("X-Cache"); got != "MISS" {
+		t.Errorf("got X-Cache %q, want MISS", got)
+	}
+	if elapsed := time.Since(start); elapsed < limiterMaxWait {
+		t.Errorf("took %v, want a wait for the limiter longer than limiterMaxWait", elapsed)
+	}
+}
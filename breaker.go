@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned instead of calling RMV while the circuit
+// breaker is open.
+var ErrCircuitOpen = errors.New("upstream circuit breaker is open")
+
+// Circuit breaker states, as reported by CircuitBreaker.State.
+const (
+	breakerClosed   = "closed"
+	breakerOpen     = "open"
+	breakerHalfOpen = "half-open"
+)
+
+// CircuitBreaker stops calls to a failing upstream. After threshold
+// consecutive failures it opens and rejects calls for cooldown. It then
+// half-opens and lets a single probe through: its success closes the
+// breaker, its failure opens it for another cooldown.
+type CircuitBreaker struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	state     string
+	// generation counts state changes, so outcomes of calls allowed in an
+	// earlier state are ignored.
+	generation uint64
+	failures   int
+	openedAt   time.Time
+	probing    bool
+	// now returns the current time; tests override it to control cooldowns.
+	now func() time.Time
+}
+
+func NewCircuitBreaker(threshold int, cooldown time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{threshold: threshold, cooldown: cooldown, state: breakerClosed, now: time.Now}
+}
+
+// breakerTicket is handed out by Allow and passed back to Record, tying an
+// outcome to the state the call was allowed in.
+type breakerTicket struct {
+	generation uint64
+	probe      bool
+}
+
+// Allow reports whether a call may be made now, returning ErrCircuitOpen
+// if not. Each allowed call must be followed by Record with the ticket.
+func (b *CircuitBreaker) Allow() (breakerTicket, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case breakerOpen:
+		if b.now().Sub(b.openedAt) < b.cooldown {
+			return breakerTicket{}, ErrCircuitOpen
+		}
+		b.setState(breakerHalfOpen)
+		slog.Info("upstream circuit breaker half-open, probing")
+	case breakerHalfOpen:
+		if b.probing {
+			return breakerTicket{}, ErrCircuitOpen
+		}
+	}
+	if b.state == breakerHalfOpen {
+		b.probing = true
+		return breakerTicket{generation: b.generation, probe: true}, nil
+	}
+	return breakerTicket{generation: b.generation}, nil
+}
+
+// Record reports the outcome of a call allowed with ticket. Only outcomes
+// of calls allowed in the current state count: in the closed state every
+// call, in the half-open state the probe. Requests RMV rejected as invalid
+// count as success, since RMV answered; calls cancelled by their caller or
+// held back by our own rate limiter or concurrency cap count as neither.
+func (b *CircuitBreaker) Record(ticket breakerTicket, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if ticket.generation != b.generation || (b.state == breakerHalfOpen && !ticket.probe) {
+		return
+	}
+	if ticket.probe {
+		b.probing = false
+	}
+
+	var apiErr *APIError
+	switch {
+	case err == nil || (errors.As(err, &apiErr) && apiErr.InvalidRequest()):
+		if b.state != breakerClosed {
+			slog.Info("upstream circuit breaker closed")
+			b.setState(breakerClosed)
+		}
+		b.failures = 0
+	case errors.Is(err, context.Canceled) || errors.Is(err, ErrRateLimited) || errors.Is(err, ErrUpstreamBusy):
+	default:
+		b.failures++
+		if b.state == breakerHalfOpen || b.failures >= b.threshold {
+			b.setState(breakerOpen)
+			b.openedAt = b.now()
+			slog.Warn("upstream circuit breaker opened", "failures", b.failures, "cooldown", b.cooldown, "error", err)
+		}
+	}
+}
+
+// setState moves to state, invalidating the tickets of the previous one.
+func (b *CircuitBreaker) setState(state string) {
+	b.state = state
+	b.generation++
+}
+
+// State returns breakerClosed, breakerOpen or breakerHalfOpen.
+func (b *CircuitBreaker) State() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
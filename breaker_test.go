@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+var errUpstream = errors.New("upstream failed")
+
+func newTestBreaker(threshold int) (*CircuitBreaker, *fakeClock) {
+	clock := &fakeClock{t: time.Date(2026, 10, 14, 8, 0, 0, 0, time.UTC)}
+	b := NewCircuitBreaker(threshold, 30*time.Second)
+	b.now = clock.now
+	return b, clock
+}
+
+// call makes one call through b with the given outcome, failing the test
+// if b does not allow it.
+func call(t *testing.T, b *CircuitBreaker, err error) {
+	t.Helper()
+	ticket, allowErr := b.Allow()
+	if allowErr != nil {
+		t.Fatalf("Allow in state %s: %v", b.State(), allowErr)
+	}
+	b.Record(ticket, err)
+}
+
+func wantState(t *testing.T, b *CircuitBreaker, want string) {
+	t.Helper()
+	if got := b.State(); got != want {
+		t.Fatalf("got state %s, want %s", got, want)
+	}
+}
+
+func TestCircuitBreakerOpensAfterThreshold(t *testing.T) {
+	b, _ := newTestBreaker(3)
+	call(t, b, errUpstream)
+	call(t, b, errUpstream)
+	call(t, b, nil)
+	call(t, b, errUpstream)
+	call(t, b, errUpstream)
+	wantState(t, b, breakerClosed)
+
+	call(t, b, errUpstream)
+	wantState(t, b, breakerOpen)
+	if _, err := b.Allow(); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("Allow while open: got %v, want ErrCircuitOpen", err)
+	}
+}
+
+func TestCircuitBreakerOutcomeClasses(t *testing.T) {
+	// Cancelled and rate-limited calls neither count nor reset.
+	b, _ := newTestBreaker(2)
+	call(t, b, errUpstream)
+	call(t, b, context.Canceled)
+	call(t, b, ErrRateLimited)
+	call(t, b, errUpstream)
+	wantState(t, b, breakerOpen)
+
+	// A request RMV rejected as invalid means RMV is up.
+	b, _ = newTestBreaker(2)
+	call(t, b, errUpstream)
+	call(t, b, &APIError{Code: "SVC_LOC"})
+	call(t, b, errUpstream)
+	wantState(t, b, breakerClosed)
+}
+
+func TestCircuitBreakerHalfOpenProbe(t *testing.T) {
+	for _, tc := range []struct {
+		probe error
+		want  string
+	}{
+		{nil, breakerClosed},
+		{errUpstream, breakerOpen},
+	} {
+		b, clock := newTestBreaker(1)
+		call(t, b, errUpstream)
+		wantState(t, b, breakerOpen)
+
+		clock.add(29 * time.Second)
+		if _, err := b.Allow(); !errors.Is(err, ErrCircuitOpen) {
+			t.Fatalf("Allow during cooldown: got %v, want ErrCircuitOpen", err)
+		}
+		clock.add(time.Second)
+		probe, err := b.Allow()
+		if err != nil {
+			t.Fatalf("Allow after cooldown: %v", err)
+		}
+		wantState(t, b, breakerHalfOpen)
+		if _, err := b.Allow(); !errors.Is(err, ErrCircuitOpen) {
+			t.Fatalf("second Allow while probing: got %v, want ErrCircuitOpen", err)
+		}
+
+		b.Record(probe, tc.probe)
+		wantState(t, b, tc.want)
+	}
+}
+
+func TestCircuitBreakerCancelledProbeAllowsAnother(t *testing.T) {
+	b, clock := newTestBreaker(1)
+	call(t, b, errUpstream)
+	clock.add(30 * time.Second)
+
+	call(t, b, context.Canceled)
+	wantState(t, b, breakerHalfOpen)
+	call(t, b, nil)
+	wantState(t, b, breakerClosed)
+}
+
+func TestCircuitBreakerIgnoresCallsFromEarlierState(t *testing.T) {
+	b, clock := newTestBreaker(1)
+	// Allowed while closed, finishing only after the breaker half-opened.
+	late, err := b.Allow()
+	if err != nil {
+		t.Fatal(err)
+	}
+	call(t, b, errUpstream)
+	clock.add(30 * time.Second)
+	probe, err := b.Allow()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b.Record(late, nil)
+	wantState(t, b, breakerHalfOpen)
+	if _, err := b.Allow(); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("late outcome let a second probe in: got %v, want ErrCircuitOpen", err)
+	}
+
+	b.Record(probe, errUpstream)
+	wantState(t, b, breakerOpen)
+	b.Record(late, nil)
+	wantState(t, b, breakerOpen)
+}
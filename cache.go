@@ -0,0 +1,256 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"log/slog"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Resources with their own cache TTL in a TTLPolicy. Boards use
+// BoardType.resource.
+const (
+	ttlDepartures = "departures"
+	ttlArrivals   = "arrivals"
+	ttlJourney    = "journey"
+	ttlSearch     = "search"
+	ttlTrip       = "trip"
+)
+
+// TTLPolicy maps a cached resource to how long it is served from cache.
+type TTLPolicy map[string]time.Duration
+
+// For returns the TTL of resource, or defaultCacheTTL for resources
+// without one.
+func (p TTLPolicy) For(resource string) time.Duration {
+	if ttl, ok := p[resource]; ok {
+		return ttl
+	}
+	return defaultCacheTTL
+}
+
+const defaultCacheTTL = 5 * time.Minute
+
+type cacheEntry struct {
+	key  string
+	item CacheItem
+}
+
+// Cache stores serialized responses with a TTL. Expired entries are retained
+// for a stale window so they can still be served via GetStale when a refresh
+// fails.
+//
+// A cache never fails a request: implementations treat their own errors as
+// a miss and log them.
+//
+// Entries hold the upstream response as received, and a key covers every
+// parameter sent upstream: boardCacheKey has the board type, stop, duration
+// and language, trips their stops and time, stop lookups their query or
+// rounded coordinates. Filtering, sorting, paging and views are applied per
+// request on the cached data, so parameters like products or fields never
+// split the cache and requests differing only in them share one entry.
+type Cache interface {
+	// Get returns the entry for key if it has not expired.
+	Get(ctx context.Context, key string) (CacheItem, bool)
+	// GetStale is like Get but also returns entries that expired less than
+	// the stale window ago.
+	GetStale(ctx context.Context, key string) (CacheItem, bool)
+	Set(ctx context.Context, key string, data []byte, ttl time.Duration)
+	// DeletePrefix removes all entries whose key starts with prefix, all
+	// entries for an empty prefix, and returns how many were removed.
+	DeletePrefix(ctx context.Context, prefix string) (int, error)
+	// Stats reports this process's lookups since startup.
+	Stats() CacheStats
+}
+
+// CacheStats counts the lookups made through Get. Entries is the number of
+// entries held; it is omitted by backends that cannot tell it cheaply.
+// Coalesced is filled in by GET /cache/stats, see coalescedFetches.
+type CacheStats struct {
+	Hits      uint64 `json:"hits"`
+	Misses    uint64 `json:"misses"`
+	Coalesced uint64 `json:"coalesced"`
+	Entries   *int   `json:"entries,omitempty"`
+}
+
+// cacheCounters are the race-free hit and miss counters shared by the
+// cache backends.
+type cacheCounters struct {
+	hits   atomic.Uint64
+	misses atomic.Uint64
+}
+
+func (c *cacheCounters) record(hit bool) {
+	if hit {
+		c.hits.Add(1)
+	} else {
+		c.misses.Add(1)
+	}
+}
+
+func (c *cacheCounters) stats() CacheStats {
+	return CacheStats{Hits: c.hits.Load(), Misses: c.misses.Load()}
+}
+
+// CacheItem is a cached value together with its timestamps.
+type CacheItem struct {
+	Data      []byte
+	StoredAt  time.Time
+	ExpiresAt time.Time
+}
+
+// MemoryCache is an in-process Cache. When maxEntries is set, the least
+// recently used entry is evicted once the limit is reached.
+type MemoryCache struct {
+	counters   cacheCounters
+	mu         sync.Mutex
+	maxEntries int
+	staleFor   time.Duration
+	entries    map[string]*list.Element
+	// order holds *cacheEntry values, most recently used at the front.
+	order *list.List
+	// now returns the current time; tests override it to control expiry.
+	now func() time.Time
+}
+
+func NewMemoryCache(maxEntries int, staleFor time.Duration) *MemoryCache {
+	return &MemoryCache{
+		maxEntries: maxEntries,
+		staleFor:   staleFor,
+		entries:    make(map[string]*list.Element),
+		order:      list.New(),
+		now:        time.Now,
+	}
+}
+
+func (c *MemoryCache) Get(_ context.Context, key string) (CacheItem, bool) {
+	item, ok := c.get(key)
+	c.counters.record(ok)
+	return item, ok
+}
+
+func (c *MemoryCache) get(key string) (CacheItem, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[key]
+	if !ok {
+		return CacheItem{}, false
+	}
+	entry := el.Value.(*cacheEntry)
+	if c.now().After(entry.item.ExpiresAt) {
+		return CacheItem{}, false
+	}
+	c.order.MoveToFront(el)
+	return entry.item, true
+}
+
+func (c *MemoryCache) GetStale(_ context.Context, key string) (CacheItem, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[key]
+	if !ok {
+		return CacheItem{}, false
+	}
+	entry := el.Value.(*cacheEntry)
+	if c.now().After(entry.item.ExpiresAt.Add(c.staleFor)) {
+		return CacheItem{}, false
+	}
+	c.order.MoveToFront(el)
+	return entry.item, true
+}
+
+func (c *MemoryCache) Set(_ context.Context, key string, data []byte, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	entry := &cacheEntry{
+		key: key,
+		item: CacheItem{
+			Data:      data,
+			StoredAt:  now,
+			ExpiresAt: now.Add(ttl),
+		},
+	}
+	if el, ok := c.entries[key]; ok {
+		el.Value = entry
+		c.order.MoveToFront(el)
+		return
+	}
+	c.entries[key] = c.order.PushFront(entry)
+
+	if c.maxEntries > 0 && c.order.Len() > c.maxEntries {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+	}
+}
+
+func (c *MemoryCache) Stats() CacheStats {
+	stats := c.counters.stats()
+	n := c.Len()
+	stats.Entries = &n
+	return stats
+}
+
+func (c *MemoryCache) DeletePrefix(_ context.Context, prefix string) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	removed := 0
+	for key, el := range c.entries {
+		if strings.HasPrefix(key, prefix) {
+			c.order.Remove(el)
+			delete(c.entries, key)
+			removed++
+		}
+	}
+	return removed, nil
+}
+
+// Len returns the number of entries currently held, including expired ones
+// that are retained as stale or have not been cleaned up yet.
+func (c *MemoryCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+// Cap returns the maximum number of entries, zero meaning unlimited.
+func (c *MemoryCache) Cap() int {
+	return c.maxEntries
+}
+
+// DeleteExpired removes all entries past their stale window and returns how
+// many were removed.
+func (c *MemoryCache) DeleteExpired() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	removed := 0
+	for key, el := range c.entries {
+		if now.After(el.Value.(*cacheEntry).item.ExpiresAt.Add(c.staleFor)) {
+			c.order.Remove(el)
+			delete(c.entries, key)
+			removed++
+		}
+	}
+	return removed
+}
+
+// RunJanitor deletes expired entries every interval until ctx is cancelled.
+func (c *MemoryCache) RunJanitor(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if n := c.DeleteExpired(); n > 0 {
+				slog.Debug("removed expired cache entries", "count", n)
+			}
+		}
+	}
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock is a settable time source for MemoryCache.now.
+type fakeClock struct {
+	mu sync.Mutex
+	t  time.Time
+}
+
+func (c *fakeClock) now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.t
+}
+
+func (c *fakeClock) add(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.t = c.t.Add(d)
+}
+
+func newTestCache(maxEntries int, staleFor time.Duration) (*MemoryCache, *fakeClock) {
+	clock := &fakeClock{t: time.Date(2026, 10, 14, 8, 0, 0, 0, time.UTC)}
+	cache := NewMemoryCache(maxEntries, staleFor)
+	cache.now = clock.now
+	return cache, clock
+}
+
+func TestMemoryCacheGetSet(t *testing.T) {
+	cache, clock := newTestCache(0, 0)
+	ctx := context.Background()
+
+	if _, ok := cache.Get(ctx, "a"); ok {
+		t.Fatal("Get on an empty cache reported a hit")
+	}
+	cache.Set(ctx, "a", []byte("one"), time.Minute)
+	item, ok := cache.Get(ctx, "a")
+	if !ok || string(item.Data) != "one" {
+		t.Fatalf("Get = %q, %v; want one, true", item.Data, ok)
+	}
+	if !item.StoredAt.Equal(clock.now()) || !item.ExpiresAt.Equal(clock.now().Add(time.Minute)) {
+		t.Errorf("got StoredAt %v ExpiresAt %v, want now and now+1m", item.StoredAt, item.ExpiresAt)
+	}
+	if stats := cache.Stats(); stats.Hits != 1 || stats.Misses != 1 || *stats.Entries != 1 {
+		t.Errorf("got stats %+v, want 1 hit, 1 miss, 1 entry", stats)
+	}
+}
+
+func TestMemoryCacheExpiry(t *testing.T) {
+	cache, clock := newTestCache(0, 10*time.Minute)
+	ctx := context.Background()
+	cache.Set(ctx, "a", []byte("one"), time.Minute)
+
+	clock.add(time.Minute)
+	if _, ok := cache.Get(ctx, "a"); !ok {
+		t.Error("entry expired at its TTL, want it served until after")
+	}
+	clock.add(time.Second)
+	if _, ok := cache.Get(ctx, "a"); ok {
+		t.Error("Get returned an expired entry")
+	}
+	if _, ok := cache.GetStale(ctx, "a"); !ok {
+		t.Error("GetStale missed an entry within the stale window")
+	}
+	clock.add(10 * time.Minute)
+	if _, ok := cache.GetStale(ctx, "a"); ok {
+		t.Error("GetStale returned an entry past the stale window")
+	}
+}
+
+func TestMemoryCacheOverwrite(t *testing.T) {
+	cache, clock := newTestCache(0, 0)
+	ctx := context.Background()
+	cache.Set(ctx, "a", []byte("one"), time.Minute)
+	clock.add(50 * time.Second)
+	cache.Set(ctx, "a", []byte("two"), time.Minute)
+	clock.add(50 * time.Second)
+
+	item, ok := cache.Get(ctx, "a")
+	if !ok || string(item.Data) != "two" {
+		t.Fatalf("Get = %q, %v; want two, true", item.Data, ok)
+	}
+	if n := cache.Len(); n != 1 {
+		t.Errorf("got %d entries, want 1", n)
+	}
+}
+
+func TestMemoryCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	cache, _ := newTestCache(2, 0)
+	ctx := context.Background()
+	cache.Set(ctx, "a", []byte("a"), time.Minute)
+	cache.Set(ctx, "b", []byte("b"), time.Minute)
+	cache.Get(ctx, "a")
+	cache.Set(ctx, "c", []byte("c"), time.Minute)
+
+	if _, ok := cache.Get(ctx, "b"); ok {
+		t.Error("least recently used entry b was kept")
+	}
+	for _, key := range []string{"a", "c"} {
+		if _, ok := cache.Get(ctx, key); !ok {
+			t.Errorf("entry %s was evicted", key)
+		}
+	}
+}
+
+// Run with -race to check the locking.
+func TestMemoryCacheConcurrentAccess(t *testing.T) {
+	cache, clock := newTestCache(50, time.Minute)
+	ctx := context.Background()
+	var wg sync.WaitGroup
+	for g := range 8 {
+		wg.Go(func() {
+			for i := range 500 {
+				key := strconv.Itoa((g*31 + i) % 100)
+				switch i % 4 {
+				case 0:
+					cache.Set(ctx, key, []byte(key), time.Second)
+				case 1:
+					cache.Get(ctx, key)
+				case 2:
+					cache.GetStale(ctx, key)
+				default:
+					clock.add(time.Millisecond)
+					cache.DeleteExpired()
+				}
+			}
+		})
+	}
+	wg.Wait()
+	if n := cache.Len(); n > 50 {
+		t.Errorf("got %d entries, want at most 50", n)
+	}
+}
+
+func TestMemoryCacheJanitorEvictsExpired(t *testing.T) {
+	cache, clock := newTestCache(0, time.Minute)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cache.Set(ctx, "short", []byte("a"), time.Minute)
+	cache.Set(ctx, "long", []byte("b"), time.Hour)
+
+	stopped := make(chan struct{})
+	go func() {
+		cache.RunJanitor(ctx, time.Millisecond)
+		close(stopped)
+	}()
+
+	// Past the TTL but within the stale window, the entry is kept.
+	clock.add(90 * time.Second)
+	time.Sleep(20 * time.Millisecond)
+	if n := cache.Len(); n != 2 {
+		t.Fatalf("got %d entries within the stale window, want 2", n)
+	}
+
+	clock.add(time.Minute)
+	deadline := time.Now().Add(time.Second)
+	for cache.Len() != 1 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if n := cache.Len(); n != 1 {
+		t.Fatalf("got %d entries after expiry, want 1", n)
+	}
+	if _, ok := cache.Get(ctx, "long"); !ok {
+		t.Error("janitor removed an unexpired entry")
+	}
+
+	cancel()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("janitor did not stop after cancellation")
+	}
+}
+
+func TestBoardCacheKeySeparatesUpstreamParameters(t *testing.T) {
+	stub := newStubRMV(t, serveBoard(5*time.Minute))
+	server := newTestServer(t, testConfig(t, stub.URL), stub.client())
+
+	// Each request either sends new parameters upstream and needs its own
+	// entry, or differs only in what is applied on top of the cached board.
+	for _, tc := range []struct {
+		path     string
+		ownEntry bool
+	}{
+		{"/next-departures?stopId=3000001", true},
+		{"/next-departures?stopId=3000002", true},
+		{"/next-departures?stopId=3000001&duration=120", true},
+		{"/next-departures?stopId=3000001&lang=en", true},
+		{"/next-arrivals?stopId=3000001", true},
+		{"/next-departures?stopId=3000001&products=tram", false},
+		{"/next-departures?stopId=3000001&fields=line", false},
+		{"/next-departures?stopId=3000001&view=compact&limit=1", false},
+		{"/next-departures?stopId=3000001&duration=120&lang=en", true},
+		{"/next-departures?stopId=3000001&lang=en&duration=120", false},
+	} {
+		before := stub.calls.Load()
+		if resp := getJSON(t, server.URL+tc.path, nil); resp.StatusCode != http.StatusOK {
+			t.Fatalf("%s: got status %d, want 200", tc.path, resp.StatusCode)
+		}
+		if fetched := stub.calls.Load() > before; fetched != tc.ownEntry {
+			t.Errorf("%s: fetched from upstream = %v, want %v", tc.path, fetched, tc.ownEntry)
+		}
+	}
+}
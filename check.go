@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// checkTimeout bounds the upstream call made by --check.
+const checkTimeout = 10 * time.Second
+
+// runCheck validates a loaded configuration for deploy pipelines: it
+// fetches the departure board of the default stop once, without retries,
+// to confirm the API key and stop ID are accepted, and prints an OK or
+// FAIL summary to out. It reports whether all checks passed.
+func runCheck(ctx context.Context, out io.Writer, config Config, configErr error) bool {
+	if configErr != nil {
+		fmt.Fprintf(out, "FAIL config: %v\n", configErr)
+		return false
+	}
+	fmt.Fprintln(out, "OK   config")
+
+	client := &RMVClient{
+		HTTPClient:       &http.Client{Transport: newUpstreamTransport(config.Transport)},
+		APIKey:           config.APIKey,
+		BaseURL:          config.BaseURL,
+		UserAgent:        config.UserAgent,
+		Retry:            RetryPolicy{MaxAttempts: 1},
+		Timeout:          checkTimeout,
+		MaxResponseBytes: config.UpstreamMaxResponseBytes,
+	}
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+	start := time.Now()
+	board, err := client.Board(ctx, BoardDepartures, config.StopID, minDuration, defaultLang)
+	if err != nil {
+		fmt.Fprintf(out, "FAIL upstream: stop %s at %s: %v\n", config.StopID, redactURL(config.BaseURL), err)
+		return false
+	}
+	fmt.Fprintf(out, "OK   upstream: stop %s answered in %s (requestId %q)\n", config.StopID, time.Since(start).Round(time.Millisecond), board.RequestID)
+	return true
+}
@@ -0,0 +1,603 @@
+package main
+
+import (
+	"cmp"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/netip"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Bounds and default for the duration query parameter, in minutes.
+const (
+	defaultDuration = 60
+	minDuration     = 1
+	maxDuration     = 1440
+)
+
+// defaultBaseURL is the production RMV HAFAS API.
+const defaultBaseURL = "https://www.rmv.de/hapi"
+
+// defaultTimezone is the local time of the RMV network.
+const defaultTimezone = "Europe/Berlin"
+
+type Config struct {
+	APIKey string
+	// AdminToken, when set, is required as a bearer token for GET /config.
+	AdminToken string
+	// BaseURL is the RMV API root the board services are resolved against.
+	BaseURL string
+	StopID  string
+	// BindAddress is the interface to listen on; empty means all interfaces.
+	BindAddress string
+	Port        string
+	// TLSCertFile and TLSKeyFile, when set, make the server speak HTTPS
+	// itself instead of relying on a TLS-terminating proxy.
+	TLSCertFile    string
+	TLSKeyFile     string
+	AllowedOrigins []string
+	// CORS tunes preflight responses for AllowedOrigins.
+	CORS CORSConfig
+	// AllowedStopIDs restricts which stops may be requested via ?stopId=.
+	// An empty list allows any stop.
+	AllowedStopIDs []string
+	// Stops are the named stops from STOPS. When STOPS is unset it holds
+	// STOP_ID under the name "default".
+	Stops []NamedStop
+	// WarmStops are further stop IDs whose departures the refresher keeps
+	// cached at all times, e.g. for fixed signage.
+	WarmStops []string
+	Retry     RetryPolicy
+	// UserAgent is sent with every request to RMV.
+	UserAgent string
+	// UpstreamTimeout bounds each request to RMV.
+	UpstreamTimeout time.Duration
+	// UpstreamRPS and UpstreamBurst pace all requests to RMV; a zero
+	// UpstreamRPS disables pacing.
+	UpstreamRPS   float64
+	UpstreamBurst int
+	// BreakerThreshold is the number of consecutive failed RMV calls that
+	// open the circuit breaker for BreakerCooldown; zero disables it.
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+	// MaxConcurrentUpstream caps simultaneous requests to RMV; zero means
+	// no cap.
+	MaxConcurrentUpstream int
+	// Transport tunes the connection pool of the RMV client.
+	Transport TransportConfig
+	// UpstreamMaxResponseBytes caps the size of an RMV response body.
+	UpstreamMaxResponseBytes int64
+	// ShutdownTimeout bounds how long in-flight requests may take to finish
+	// after a termination signal.
+	ShutdownTimeout time.Duration
+	// TrustedProxies are the peers whose X-Forwarded-For is believed when
+	// determining client IPs; see clientIPMiddleware.
+	TrustedProxies []netip.Prefix
+	// RateLimitRPS and RateLimitBurst configure the per-client-IP token bucket.
+	RateLimitRPS   float64
+	RateLimitBurst int
+	// RefreshInterval is how often hot boards are re-fetched in the background.
+	// It should stay below the cache TTL; zero disables background refresh.
+	RefreshInterval time.Duration
+	// CacheBackend selects where responses are cached: "memory" (the
+	// default, per process) or "redis" (shared, at RedisURL).
+	CacheBackend string
+	RedisURL     string
+	// CachePrefix namespaces the keys in Redis, so several services or
+	// environments can share one server. The in-memory cache is private
+	// to the process and does not use it.
+	CachePrefix string
+	// CacheTTLs is how long each resource is served from cache, from
+	// CACHE_TTL_<RESOURCE>; see loadTTLPolicy.
+	CacheTTLs TTLPolicy
+	// StopTTLs override the board TTL of single stops, from STOP_TTL.
+	StopTTLs map[string]time.Duration
+	// FreshMaxAge bounds the age of boards requested with ?fresh=true.
+	//
+	// Real-time delays change faster than the board TTL, so platform displays may
+	// ask for fresh data. Rather than bypassing the cache outright, which
+	// would let every such client hit RMV, a fresh request refreshes the
+	// board only if it is older than FreshMaxAge. Upstream load from fresh
+	// requests is thereby capped at one call per board per FreshMaxAge, no
+	// matter how many clients ask; the price is that "fresh" data can still
+	// be up to FreshMaxAge old.
+	FreshMaxAge time.Duration
+	// StaleWindow is how long past its TTL a board may still be served when
+	// the upstream is failing; zero disables stale serving.
+	StaleWindow time.Duration
+	// CacheMaxEntries caps the number of cached boards in the memory
+	// backend; zero means unlimited.
+	CacheMaxEntries int
+	// WarmCache fetches the configured stops before the server starts
+	// listening.
+	WarmCache bool
+	// MaintenanceMode starts the service without upstream calls, serving
+	// cached data only; see RMVClient.Maintenance.
+	MaintenanceMode bool
+	// CacheCleanupInterval is how often expired entries are purged.
+	CacheCleanupInterval time.Duration
+	// RequestTimeout is the server-side deadline applied to every request.
+	RequestTimeout time.Duration
+	// StreamInterval is how often SSE streams re-check their board.
+	StreamInterval time.Duration
+	// BatchMaxStops caps the number of stops per /departures/batch request.
+	BatchMaxStops int
+	// BatchMaxBodyBytes caps the size of a /departures/batch request body.
+	BatchMaxBodyBytes int64
+	// BatchConcurrency caps how many stops of one batch are fetched at once.
+	BatchConcurrency int
+	// GzipMinSize is the smallest response body, in bytes, that is gzip
+	// compressed for clients that accept it.
+	GzipMinSize int
+	// NoServiceMessage is shown on boards without entries within the
+	// requested window, e.g. at night. Setting it also adds serviceActive
+	// to board responses.
+	NoServiceMessage string
+	// DefaultLimit caps the entries per board response when ?limit= is
+	// absent; zero returns all entries.
+	DefaultLimit int
+	// Location is the timezone RMV times are interpreted in, from TIMEZONE
+	// or TZ.
+	Location *time.Location
+}
+
+// stopAllowed reports whether departures for stopID may be served.
+// The default stop, all named stops and the warm stops are always allowed.
+func (c Config) stopAllowed(stopID string) bool {
+	if len(c.AllowedStopIDs) == 0 || slices.Contains(c.AllowedStopIDs, stopID) {
+		return true
+	}
+	return slices.Contains(c.keptStops(), stopID)
+}
+
+// keptStops returns the IDs of the default stop, the named stops and the
+// warm stops without duplicates. Their departures are warmed at startup
+// and kept fresh by the refresher.
+func (c Config) keptStops() []string {
+	ids := []string{c.StopID}
+	for _, stop := range c.Stops {
+		ids = append(ids, stop.ID)
+	}
+	ids = append(ids, c.WarmStops...)
+	var kept []string
+	for _, id := range ids {
+		if id != "" && !slices.Contains(kept, id) {
+			kept = append(kept, id)
+		}
+	}
+	return kept
+}
+
+// envInt reads an integer env var, falling back to def when it is unset or invalid.
+func envInt(name string, def int) int {
+	raw := os.Getenv(name)
+	if raw == "" {
+		return def
+	}
+	v, err := strconv.Atoi(raw)
+	if err != nil {
+		slog.Warn("invalid integer in env, using default", "var", name, "value", raw, "default", def)
+		return def
+	}
+	return v
+}
+
+// envFloat reads a floating point env var, falling back to def when it is unset or invalid.
+func envFloat(name string, def float64) float64 {
+	raw := os.Getenv(name)
+	if raw == "" {
+		return def
+	}
+	v, err := strconv.ParseFloat(raw, 64)
+	if err != nil {
+		slog.Warn("invalid number in env, using default", "var", name, "value", raw, "default", def)
+		return def
+	}
+	return v
+}
+
+// envBool reads a boolean env var ("true", "false", "1", "0", ...), falling
+// back to def when it is unset or invalid.
+func envBool(name string, def bool) bool {
+	raw := os.Getenv(name)
+	if raw == "" {
+		return def
+	}
+	v, err := strconv.ParseBool(raw)
+	if err != nil {
+		slog.Warn("invalid boolean in env, using default", "var", name, "value", raw, "default", def)
+		return def
+	}
+	return v
+}
+
+// envSecret reads a secret from name, or from the file named by name+"_FILE"
+// as used for Docker and Kubernetes secrets. The file wins when both are
+// set; trailing whitespace and newlines are trimmed from its contents.
+func envSecret(name string) (string, error) {
+	path := os.Getenv(name + "_FILE")
+	if path == "" {
+		return os.Getenv(name), nil
+	}
+	if os.Getenv(name) != "" {
+		slog.Warn("both "+name+" and "+name+"_FILE are set, using the file", "file", path)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("%s_FILE: %v", name, err)
+	}
+	return strings.TrimRight(string(data), " \t\r\n"), nil
+}
+
+// envDuration reads a Go duration env var (e.g. "2m30s"), falling back to def
+// when it is unset or invalid.
+func envDuration(name string, def time.Duration) time.Duration {
+	raw := os.Getenv(name)
+	if raw == "" {
+		return def
+	}
+	v, err := time.ParseDuration(raw)
+	if err != nil {
+		slog.Warn("invalid duration in env, using default", "var", name, "value", raw, "default", def)
+		return def
+	}
+	return v
+}
+
+// splitList splits a comma-separated env value, dropping empty entries.
+func splitList(raw string) []string {
+	var list []string
+	for _, item := range strings.Split(raw, ",") {
+		if trimmed := strings.TrimSpace(item); trimmed != "" {
+			list = append(list, trimmed)
+		}
+	}
+	return list
+}
+
+// loadTTLPolicy reads the cache TTL of each resource from
+// CACHE_TTL_<RESOURCE>, e.g. CACHE_TTL_SEARCH. The older CACHE_TTL (for
+// both board types), JOURNEY_CACHE_TTL and LOCATION_CACHE_TTL are still
+// honored as fallbacks. Stop searches change rarely and are kept much
+// longer than real-time boards by default.
+func loadTTLPolicy() TTLPolicy {
+	policy := TTLPolicy{}
+	for _, p := range []struct {
+		resource string
+		legacy   string
+		def      time.Duration
+	}{
+		{ttlDepartures, "CACHE_TTL", defaultCacheTTL},
+		{ttlArrivals, "CACHE_TTL", defaultCacheTTL},
+		{ttlJourney, "JOURNEY_CACHE_TTL", time.Minute},
+		{ttlSearch, "LOCATION_CACHE_TTL", time.Hour},
+		{ttlTrip, "", time.Minute},
+	} {
+		name := "CACHE_TTL_" + strings.ToUpper(p.resource)
+		ttl := envDuration(name, envDuration(p.legacy, p.def))
+		if ttl <= 0 {
+			slog.Warn(name+" must be positive, using default", "value", ttl, "default", p.def)
+			ttl = p.def
+		}
+		policy[p.resource] = ttl
+	}
+	return policy
+}
+
+// parseStopTTLs parses STOP_TTL, a comma-separated list of stopID=duration
+// pairs such as "3000001=2m,3000510=10m". Malformed entries are logged and
+// skipped.
+func parseStopTTLs(raw string) map[string]time.Duration {
+	ttls := make(map[string]time.Duration)
+	for _, entry := range splitList(raw) {
+		id, rawTTL, ok := strings.Cut(entry, "=")
+		id = strings.TrimSpace(id)
+		ttl, err := time.ParseDuration(strings.TrimSpace(rawTTL))
+		if !ok || id == "" || err != nil || ttl <= 0 {
+			slog.Warn("ignoring malformed STOP_TTL entry, expected stopId=duration", "entry", entry)
+			continue
+		}
+		ttls[id] = ttl
+	}
+	return ttls
+}
+
+// boardTTL is how long a board of stopID is cached: its STOP_TTL override
+// or else the TTL of the board type.
+func (c Config) boardTTL(boardType BoardType, stopID string) time.Duration {
+	if ttl, ok := c.StopTTLs[stopID]; ok {
+		return ttl
+	}
+	return c.CacheTTLs.For(boardType.resource())
+}
+
+// ConfigError lists every problem found while loading the configuration.
+type ConfigError struct {
+	Problems []string
+}
+
+func (e *ConfigError) Error() string {
+	return strings.Join(e.Problems, "; ")
+}
+
+// LoadConfig reads the configuration from the environment and applies
+// defaults. Malformed optional settings fall back to their default with a
+// warning; missing or invalid required settings are collected and returned
+// together as a *ConfigError.
+func LoadConfig() (Config, error) {
+	var problems []string
+
+	config := Config{
+		BaseURL:        cmp.Or(os.Getenv("RMV_BASE_URL"), defaultBaseURL),
+		StopID:         os.Getenv("STOP_ID"),
+		BindAddress:    os.Getenv("BIND_ADDRESS"),
+		Port:           cmp.Or(os.Getenv("PORT"), "8080"),
+		TLSCertFile:    strings.TrimSpace(os.Getenv("TLS_CERT_FILE")),
+		TLSKeyFile:     strings.TrimSpace(os.Getenv("TLS_KEY_FILE")),
+		AllowedOrigins: parseAllowedOrigins(os.Getenv("ALLOWED_ORIGINS")),
+		CORS: CORSConfig{
+			AllowMethods: cmp.Or(joinHeaderList(os.Getenv("CORS_ALLOW_METHODS")), defaultCORSMethods),
+			AllowHeaders: joinHeaderList(os.Getenv("CORS_ALLOW_HEADERS")),
+			MaxAge:       envDuration("CORS_MAX_AGE", 0),
+		},
+		AllowedStopIDs: splitList(os.Getenv("ALLOWED_STOP_IDS")),
+		WarmStops:      splitList(os.Getenv("WARM_STOPS")),
+		UserAgent:      cmp.Or(os.Getenv("USER_AGENT"), "rmv-backend-go/"+version),
+		Retry: RetryPolicy{
+			MaxAttempts: envInt("UPSTREAM_MAX_ATTEMPTS", 3),
+			BaseBackoff: envDuration("UPSTREAM_RETRY_BACKOFF", 200*time.Millisecond),
+		},
+		UpstreamTimeout:       envDuration("UPSTREAM_TIMEOUT", 10*time.Second),
+		UpstreamRPS:           envFloat("UPSTREAM_RATE_LIMIT_RPS", 5),
+		UpstreamBurst:         envInt("UPSTREAM_RATE_LIMIT_BURST", 5),
+		MaxConcurrentUpstream: envInt("MAX_CONCURRENT_UPSTREAM", 10),
+		BreakerThreshold:      envInt("BREAKER_THRESHOLD", 5),
+		BreakerCooldown:       envDuration("BREAKER_COOLDOWN", 30*time.Second),
+		Transport: TransportConfig{
+			// See newUpstreamTransport for the reasoning behind the defaults.
+			MaxIdleConns:        envInt("UPSTREAM_MAX_IDLE_CONNS", 100),
+			MaxIdleConnsPerHost: envInt("UPSTREAM_MAX_IDLE_CONNS_PER_HOST", 16),
+			IdleConnTimeout:     envDuration("UPSTREAM_IDLE_CONN_TIMEOUT", 90*time.Second),
+		},
+		UpstreamMaxResponseBytes: int64(envInt("UPSTREAM_MAX_RESPONSE_BYTES", 5<<20)),
+		ShutdownTimeout:          envDuration("SHUTDOWN_TIMEOUT", 15*time.Second),
+		RateLimitRPS:             envFloat("RATE_LIMIT_RPS", 2),
+		RateLimitBurst:           envInt("RATE_LIMIT_BURST", 10),
+		CacheBackend:             strings.ToLower(cmp.Or(os.Getenv("CACHE_BACKEND"), "memory")),
+		CachePrefix:              cmp.Or(os.Getenv("CACHE_PREFIX"), defaultCachePrefix),
+		CacheTTLs:                loadTTLPolicy(),
+		StopTTLs:                 parseStopTTLs(os.Getenv("STOP_TTL")),
+		FreshMaxAge:              envDuration("FRESH_MAX_AGE", 30*time.Second),
+		StaleWindow:              envDuration("STALE_WINDOW", 30*time.Minute),
+		CacheMaxEntries:          envInt("CACHE_MAX_ENTRIES", 1000),
+		WarmCache:                envBool("WARM_CACHE", true),
+		MaintenanceMode:          envBool("MAINTENANCE_MODE", false),
+		CacheCleanupInterval:     envDuration("CACHE_CLEANUP_INTERVAL", time.Minute),
+		RequestTimeout:           envDuration("REQUEST_TIMEOUT", 15*time.Second),
+		StreamInterval:           envDuration("STREAM_INTERVAL", 30*time.Second),
+		DefaultLimit:             envInt("DEFAULT_LIMIT", 0),
+		NoServiceMessage:         strings.TrimSpace(os.Getenv("NO_SERVICE_MESSAGE")),
+		GzipMinSize:              envInt("GZIP_MIN_SIZE", 1024),
+		BatchMaxStops:            envInt("BATCH_MAX_STOPS", 10),
+		BatchMaxBodyBytes:        int64(envInt("BATCH_MAX_BODY_BYTES", 64<<10)),
+		BatchConcurrency:         envInt("BATCH_CONCURRENCY", 4),
+	}
+	for _, secret := range []struct {
+		name string
+		dst  *string
+	}{
+		{"RMV_API_KEY", &config.APIKey},
+		{"ADMIN_TOKEN", &config.AdminToken},
+		{"REDIS_URL", &config.RedisURL},
+	} {
+		value, err := envSecret(secret.name)
+		if err != nil {
+			problems = append(problems, err.Error())
+		}
+		*secret.dst = value
+	}
+
+	// Refresh a little before entries expire so reads keep hitting the cache.
+	config.RefreshInterval = envDuration("REFRESH_INTERVAL", config.CacheTTLs.For(ttlDepartures)*4/5)
+	if len(config.WarmStops) > 0 && config.RefreshInterval <= 0 {
+		slog.Warn("WARM_STOPS has no effect with background refresh disabled", "warmStops", config.WarmStops)
+	}
+
+	if config.APIKey == "" {
+		problems = append(problems, "RMV_API_KEY is required")
+	}
+	if u, err := url.Parse(config.BaseURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		problems = append(problems, fmt.Sprintf("RMV_BASE_URL must be an absolute http(s) URL, got %q", config.BaseURL))
+	}
+
+	if port, err := strconv.Atoi(config.Port); err != nil || port < 1 || port > 65535 {
+		problems = append(problems, fmt.Sprintf("PORT must be a number between 1 and 65535, got %q", config.Port))
+	}
+	if addr := config.BindAddress; addr != "" && net.ParseIP(addr) == nil && strings.ContainsAny(addr, ":/[] ") {
+		problems = append(problems, fmt.Sprintf("BIND_ADDRESS must be an IP address or host name without a port, got %q", addr))
+	}
+	if (config.TLSCertFile == "") != (config.TLSKeyFile == "") {
+		problems = append(problems, "TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+
+	proxies, err := parseTrustedProxies(os.Getenv("TRUSTED_PROXIES"))
+	if err != nil {
+		problems = append(problems, err.Error())
+	}
+	config.TrustedProxies = proxies
+
+	if config.BatchMaxBodyBytes <= 0 {
+		slog.Warn("BATCH_MAX_BODY_BYTES must be positive, using default", "value", config.BatchMaxBodyBytes)
+		config.BatchMaxBodyBytes = 64 << 10
+	}
+	if config.BatchConcurrency <= 0 {
+		slog.Warn("BATCH_CONCURRENCY must be positive, using default", "value", config.BatchConcurrency)
+		config.BatchConcurrency = 4
+	}
+
+	switch config.CacheBackend {
+	case "memory":
+	case "redis":
+		if config.RedisURL == "" {
+			problems = append(problems, "REDIS_URL is required when CACHE_BACKEND=redis")
+		} else if _, err := redis.ParseURL(config.RedisURL); err != nil {
+			problems = append(problems, fmt.Sprintf("REDIS_URL is invalid: %v", err))
+		}
+	default:
+		problems = append(problems, fmt.Sprintf("CACHE_BACKEND must be memory or redis, got %q", config.CacheBackend))
+	}
+
+	tz := cmp.Or(os.Getenv("TIMEZONE"), os.Getenv("TZ"), defaultTimezone)
+	if loc, err := loadLocation(tz); err != nil {
+		problems = append(problems, fmt.Sprintf("TIMEZONE %q is invalid: %v", tz, err))
+	} else {
+		config.Location = loc
+	}
+
+	stops, err := parseStops(os.Getenv("STOPS"))
+	if err != nil {
+		problems = append(problems, err.Error())
+	}
+	config.Stops = stops
+	if config.StopID == "" && len(config.Stops) > 0 {
+		config.StopID = config.Stops[0].ID
+	}
+	if config.StopID == "" && err == nil {
+		problems = append(problems, "STOP_ID or STOPS is required")
+	}
+	if len(config.Stops) == 0 && config.StopID != "" {
+		config.Stops = []NamedStop{{Name: "default", ID: config.StopID}}
+	}
+
+	if len(problems) > 0 {
+		return config, &ConfigError{Problems: problems}
+	}
+	return config, nil
+}
+
+// configReport is the effective configuration as shown by GET /config, with
+// secrets redacted.
+type configReport struct {
+	APIKeyFingerprint        string            `json:"apiKeyFingerprint"`
+	BaseURL                  string            `json:"baseUrl"`
+	StopID                   string            `json:"stopId"`
+	Stops                    []NamedStop       `json:"stops"`
+	WarmStops                []string          `json:"warmStops"`
+	AllowedStopIDs           []string          `json:"allowedStopIds"`
+	BindAddress              string            `json:"bindAddress"`
+	Port                     string            `json:"port"`
+	TLSCertFile              string            `json:"tlsCertFile"`
+	TLSKeyFile               string            `json:"tlsKeyFile"`
+	AllowedOrigins           []string          `json:"allowedOrigins"`
+	CORSAllowMethods         string            `json:"corsAllowMethods"`
+	CORSAllowHeaders         string            `json:"corsAllowHeaders,omitempty"`
+	CORSMaxAge               string            `json:"corsMaxAge"`
+	Timezone                 string            `json:"timezone"`
+	CacheBackend             string            `json:"cacheBackend"`
+	CachePrefix              string            `json:"cachePrefix"`
+	RedisURL                 string            `json:"redisUrl,omitempty"`
+	CacheTTLs                map[string]string `json:"cacheTtls"`
+	StopTTLs                 map[string]string `json:"stopTtls"`
+	StaleWindow              string            `json:"staleWindow"`
+	CacheMaxEntries          int               `json:"cacheMaxEntries"`
+	RefreshInterval          string            `json:"refreshInterval"`
+	UpstreamTimeout          string            `json:"upstreamTimeout"`
+	UserAgent                string            `json:"userAgent"`
+	UpstreamMaxAttempts      int               `json:"upstreamMaxAttempts"`
+	UpstreamRPS              float64           `json:"upstreamRps"`
+	MaxConcurrentUpstream    int               `json:"maxConcurrentUpstream"`
+	UpstreamMaxResponseBytes int64             `json:"upstreamMaxResponseBytes"`
+	RequestTimeout           string            `json:"requestTimeout"`
+	TrustedProxies           []netip.Prefix    `json:"trustedProxies"`
+	RateLimitRPS             float64           `json:"rateLimitRps"`
+	RateLimitBurst           int               `json:"rateLimitBurst"`
+	DefaultLimit             int               `json:"defaultLimit"`
+	BatchMaxStops            int               `json:"batchMaxStops"`
+	BatchMaxBodyBytes        int64             `json:"batchMaxBodyBytes"`
+	BatchConcurrency         int               `json:"batchConcurrency"`
+	MaintenanceMode          bool              `json:"maintenanceMode"`
+	AdminTokenConfigured     bool              `json:"adminTokenConfigured"`
+}
+
+// report returns the configuration with the API key replaced by a
+// fingerprint and credentials removed from URLs.
+func (c Config) report() configReport {
+	r := configReport{
+		APIKeyFingerprint:        fingerprint(c.APIKey),
+		BaseURL:                  redactURL(c.BaseURL),
+		StopID:                   c.StopID,
+		Stops:                    c.Stops,
+		WarmStops:                c.WarmStops,
+		AllowedStopIDs:           c.AllowedStopIDs,
+		BindAddress:              c.BindAddress,
+		Port:                     c.Port,
+		TLSCertFile:              c.TLSCertFile,
+		TLSKeyFile:               c.TLSKeyFile,
+		AllowedOrigins:           c.AllowedOrigins,
+		CORSAllowMethods:         c.CORS.AllowMethods,
+		CORSAllowHeaders:         c.CORS.AllowHeaders,
+		CORSMaxAge:               c.CORS.MaxAge.String(),
+		Timezone:                 c.Location.String(),
+		CacheBackend:             c.CacheBackend,
+		CachePrefix:              c.CachePrefix,
+		CacheTTLs:                make(map[string]string, len(c.CacheTTLs)),
+		StopTTLs:                 make(map[string]string, len(c.StopTTLs)),
+		StaleWindow:              c.StaleWindow.String(),
+		CacheMaxEntries:          c.CacheMaxEntries,
+		RefreshInterval:          c.RefreshInterval.String(),
+		UpstreamTimeout:          c.UpstreamTimeout.String(),
+		UserAgent:                c.UserAgent,
+		UpstreamMaxAttempts:      c.Retry.MaxAttempts,
+		UpstreamRPS:              c.UpstreamRPS,
+		MaxConcurrentUpstream:    c.MaxConcurrentUpstream,
+		UpstreamMaxResponseBytes: c.UpstreamMaxResponseBytes,
+		RequestTimeout:           c.RequestTimeout.String(),
+		TrustedProxies:           c.TrustedProxies,
+		RateLimitRPS:             c.RateLimitRPS,
+		RateLimitBurst:           c.RateLimitBurst,
+		DefaultLimit:             c.DefaultLimit,
+		BatchMaxStops:            c.BatchMaxStops,
+		BatchMaxBodyBytes:        c.BatchMaxBodyBytes,
+		BatchConcurrency:         c.BatchConcurrency,
+		MaintenanceMode:          c.MaintenanceMode,
+		AdminTokenConfigured:     c.AdminToken != "",
+	}
+	for resource, ttl := range c.CacheTTLs {
+		r.CacheTTLs[resource] = ttl.String()
+	}
+	for stopID, ttl := range c.StopTTLs {
+		r.StopTTLs[stopID] = ttl.String()
+	}
+	if c.RedisURL != "" {
+		r.RedisURL = redactURL(c.RedisURL)
+	}
+	return r
+}
+
+// fingerprint identifies a secret without revealing it: the first 8 hex
+// digits of its SHA-256, or "" when unset.
+func fingerprint(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(secret))
+	return "sha256:" + hex.EncodeToString(sum[:4])
+}
+
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "(invalid)"
+	}
+	return u.Redacted()
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORSConfig sets what preflight responses allow.
+type CORSConfig struct {
+	// AllowMethods is sent as Access-Control-Allow-Methods.
+	AllowMethods string
+	// AllowHeaders is sent as Access-Control-Allow-Headers. When empty, the
+	// headers a preflight asks for are echoed, defaulting to
+	// defaultCORSHeaders.
+	AllowHeaders string
+	// MaxAge lets browsers cache preflight results; zero omits
+	// Access-Control-Max-Age.
+	MaxAge time.Duration
+}
+
+const (
+	defaultCORSMethods = "GET, POST, OPTIONS"
+	defaultCORSHeaders = "Content-Type, Authorization"
+)
+
+// joinHeaderList normalizes a comma-separated env value to a header list.
+func joinHeaderList(raw string) string {
+	return strings.Join(splitList(raw), ", ")
+}
+
+// parseAllowedOrigins validates and normalizes ALLOWED_ORIGINS. Each entry
+// must be "*", a scheme+host origin such as "https://example.com" or a
+// wildcard such as "https://*.example.com" matching any single-label
+// subdomain; malformed entries are logged and skipped.
+func parseAllowedOrigins(raw string) []string {
+	var origins []string
+	for _, entry := range splitList(raw) {
+		origin, ok := normalizeOrigin(entry)
+		if !ok {
+			slog.Warn("ignoring malformed ALLOWED_ORIGINS entry, expected scheme://host[:port]", "entry", entry)
+			continue
+		}
+		origins = append(origins, origin)
+	}
+	if len(origins) == 0 {
+		slog.Warn("no valid ALLOWED_ORIGINS configured, CORS will block all cross-origin requests")
+	}
+	return origins
+}
+
+func normalizeOrigin(entry string) (string, bool) {
+	if entry == "*" {
+		return entry, true
+	}
+	u, err := url.Parse(strings.TrimRight(entry, "/"))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" ||
+		u.Path != "" || u.RawQuery != "" || u.Fragment != "" || u.User != nil {
+		return "", false
+	}
+	if rest, wildcard := strings.CutPrefix(u.Host, "*."); strings.Contains(rest, "*") || (!wildcard && strings.Contains(u.Host, "*")) {
+		return "", false
+	}
+	return strings.ToLower(u.Scheme + "://" + u.Host), true
+}
+
+// originAllowed reports whether origin matches one of the normalized
+// allowed entries. A wildcard entry "https://*.example.com" matches exactly
+// one extra label: "https://a.example.com" but neither
+// "https://a.b.example.com", "https://example.com" nor
+// "https://evil-example.com".
+func originAllowed(origin string, allowed []string) bool {
+	origin = strings.ToLower(origin)
+	for _, entry := range allowed {
+		if entry == "*" || entry == origin {
+			return true
+		}
+		scheme, suffix, ok := strings.Cut(entry, "://*.")
+		if !ok {
+			continue
+		}
+		host, ok := strings.CutPrefix(origin, scheme+"://")
+		if !ok {
+			continue
+		}
+		label, ok := strings.CutSuffix(host, "."+suffix)
+		if ok && label != "" && !strings.ContainsAny(label, ".:") {
+			return true
+		}
+	}
+	return false
+}
+
+// corsMiddleware answers CORS requests from allowedOrigins. Since the
+// allowed origin is echoed, responses vary by Origin. Unless
+// cors.AllowHeaders is set, preflights echo the requested headers so
+// clients may send custom ones.
+func corsMiddleware(next http.Handler, allowedOrigins []string, cors CORSConfig) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin != "" && originAllowed(origin, allowedOrigins) {
+			h := w.Header()
+			h.Set("Access-Control-Allow-Origin", origin)
+			h.Add("Vary", "Origin")
+			if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+				h.Set("Access-Control-Allow-Methods", cors.AllowMethods)
+				h.Add("Vary", "Access-Control-Request-Method")
+				h.Add("Vary", "Access-Control-Request-Headers")
+				if cors.AllowHeaders != "" {
+					h.Set("Access-Control-Allow-Headers", cors.AllowHeaders)
+				} else if requested := r.Header.Get("Access-Control-Request-Headers"); requested != "" {
+					h.Set("Access-Control-Allow-Headers", requested)
+				} else {
+					h.Set("Access-Control-Allow-Headers", defaultCORSHeaders)
+				}
+				if cors.MaxAge > 0 {
+					h.Set("Access-Control-Max-Age", strconv.Itoa(int(cors.MaxAge.Seconds())))
+				}
+			}
+		}
+
+		if r.Method == http.MethodOptions {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestOriginAllowedWildcard(t *testing.T) {
+	allowed := parseAllowedOrigins("https://*.example.com, http://localhost:3000/")
+	for _, tc := range []struct {
+		origin string
+		want   bool
+	}{
+		{"https://a.example.com", true},
+		{"https://A.Example.com", true},
+		{"http://localhost:3000", true},
+		{"https://evil-example.com", false},
+		{"https://a.b.example.com", false},
+		{"https://example.com", false},
+		{"https://.example.com", false},
+		{"http://a.example.com", false},
+		{"https://a.example.com:8443", false},
+		{"https://a.example.com.evil.org", false},
+		{"http://localhost:3001", false},
+	} {
+		if got := originAllowed(tc.origin, allowed); got != tc.want {
+			t.Errorf("originAllowed(%q) = %v, want %v", tc.origin, got, tc.want)
+		}
+	}
+}
+
+func TestNormalizeOriginRejectsMalformed(t *testing.T) {
+	for _, entry := range []string{
+		"example.com",
+		"ftp://example.com",
+		"https://example.com/path",
+		"https://*.*.example.com",
+		"https://a.*.example.com",
+		"https://user@example.com",
+	} {
+		if origin, ok := normalizeOrigin(entry); ok {
+			t.Errorf("normalizeOrigin(%q) = %q, want it rejected", entry, origin)
+		}
+	}
+}
+
+func TestCORSMiddlewareEchoesAllowedOrigin(t *testing.T) {
+	handler := corsMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+		parseAllowedOrigins("https://*.example.com"), CORSConfig{AllowMethods: "GET"})
+	for origin, want := range map[string]string{
+		"https://a.example.com":    "https://a.example.com",
+		"https://evil-example.com": "",
+		"https://a.b.example.com":  "",
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/next-departures", nil)
+		req.Header.Set("Origin", origin)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if got := rec.Header().Get("Access-Control-Allow-Origin"); got != want {
+			t.Errorf("Origin %s: got Access-Control-Allow-Origin %q, want %q", origin, got, want)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"strconv"
+	"time"
+)
+
+var csvHeader = []string{"line", "direction", "scheduled", "realtime", "delay", "platform"}
+
+// boardCSV renders the entries of board as CSV with a header row. Times are
+// RFC 3339 in loc, delay is in whole minutes and both realtime and delay are
+// empty when there is no real-time prognosis. For arrivals the direction
+// column holds the origin.
+func boardCSV(board *DepartureBoard, loc *time.Location) ([]byte, error) {
+	var buf bytes.Buffer
+	cw := csv.NewWriter(&buf)
+	cw.Write(csvHeader)
+	for _, d := range board.entries() {
+		row := newBoardRow(d, loc)
+		cw.Write([]string{row.Line, row.Direction, row.Scheduled, row.Realtime, row.Delay, row.Platform})
+	}
+	cw.Flush()
+	return buf.Bytes(), cw.Error()
+}
+
+// boardRow is an entry flattened to text for the tabular formats, with the
+// columns described at boardCSV.
+type boardRow struct {
+	Line      string
+	Direction string
+	Scheduled string
+	Realtime  string
+	Delay     string
+	Platform  string
+}
+
+func newBoardRow(d Departure, loc *time.Location) boardRow {
+	row := boardRow{Line: d.line(), Direction: d.Direction, Platform: d.RtTrack}
+	if t, err := d.ScheduledTime(loc); err == nil {
+		row.Scheduled = t.Format(time.RFC3339)
+	}
+	if d.RtTime != "" {
+		if t, err := d.EffectiveTime(loc); err == nil {
+			row.Realtime = t.Format(time.RFC3339)
+		}
+		if dl, ok := d.Delay(loc); ok {
+			row.Delay = strconv.Itoa(int(dl / time.Minute))
+		}
+	}
+	if row.Direction == "" {
+		row.Direction = d.Origin
+	}
+	if row.Platform == "" {
+		row.Platform = d.Track
+	}
+	return row
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// boardCursor is the position of the next page of a board. It is tied to
+// the cached snapshot it was issued for, so paging never mixes entries of
+// two upstream fetches.
+type boardCursor struct {
+	// StoredAt identifies the snapshot, as CacheItem.StoredAt in Unix
+	// nanoseconds.
+	StoredAt int64
+	// Offset is the index of the first entry of the page in the filtered,
+	// sorted snapshot, counting entries that have departed since.
+	Offset int
+}
+
+func (c boardCursor) String() string {
+	return base64.RawURLEncoding.EncodeToString(fmt.Appendf(nil, "%d.%d", c.StoredAt, c.Offset))
+}
+
+func parseCursor(raw string) (*boardCursor, *requestError) {
+	data, err := base64.RawURLEncoding.DecodeString(raw)
+	var c boardCursor
+	if err == nil {
+		_, err = fmt.Sscanf(string(data), "%d.%d", &c.StoredAt, &c.Offset)
+	}
+	if err != nil || c.Offset < 0 {
+		return nil, badParameter("cursor is invalid")
+	}
+	return &c, nil
+}
+
+// checkSnapshot reports a cursor issued for an older snapshot than
+// storedAt. Its offset would skip or repeat entries, so the client has to
+// start over.
+func (c *boardCursor) checkSnapshot(storedAt time.Time) *requestError {
+	if c == nil || c.StoredAt == storedAt.UnixNano() {
+		return nil
+	}
+	return &requestError{Status: http.StatusGone, Code: "cursor_expired", Message: "the board has been refreshed since this cursor was issued; request the first page again"}
+}
+
+// skipBoard returns board without its first n entries.
+func skipBoard(board *DepartureBoard, n int) *DepartureBoard {
+	skipped := *board
+	skipped.Departures = board.Departures[min(n, len(board.Departures)):]
+	skipped.Arrivals = board.Arrivals[min(n, len(board.Arrivals)):]
+	return &skipped
+}
@@ -0,0 +1,198 @@
+package main
+
+// BoardType selects which HAFAS board service is queried. Its value is the
+// service path below the API base URL.
+type BoardType string
+
+const (
+	BoardDepartures BoardType = "departureBoard"
+	BoardArrivals   BoardType = "arrivalBoard"
+)
+
+// resource names the board contents for log and error messages.
+func (t BoardType) resource() string {
+	if t == BoardArrivals {
+		return "arrivals"
+	}
+	return "departures"
+}
+
+// DepartureBoard mirrors the departureBoard and arrivalBoard JSON returned by
+// the RMV HAFAS API. Departure boards fill Departures, arrival boards Arrivals.
+//
+// Only the fields the frontend relies on are typed. Anything else the upstream
+// sends is ignored by the decoder, so new or unknown HAFAS fields never cause
+// a decode failure.
+type DepartureBoard struct {
+	Departures     []Departure `json:"Departure,omitempty"`
+	Arrivals       []Departure `json:"Arrival,omitempty"`
+	ServerVersion  string      `json:"serverVersion,omitempty"`
+	DialectVersion string      `json:"dialectVersion,omitempty"`
+	RequestID      string      `json:"requestId,omitempty"`
+
+	// NextOffset is set by boardQuery.apply when a page has more entries.
+	NextOffset int `json:"-"`
+	// Meta is passed on to the response body by the views.
+	Meta boardMeta `json:"-"`
+}
+
+// boardMeta are the top-level fields of board responses besides the
+// entries, each omitted when unset.
+type boardMeta struct {
+	// NextCursor continues a paged board; see boardCursor.
+	NextCursor string `json:"nextCursor,omitempty"`
+	// ServiceActive reports whether the board has any entries. It and
+	// Message are only set when NO_SERVICE_MESSAGE is configured, Message
+	// only on empty boards.
+	ServiceActive *bool  `json:"serviceActive,omitempty"`
+	Message       string `json:"message,omitempty"`
+}
+
+// departuresResponse and arrivalsResponse are the bodies of the board
+// endpoints. The entry list is always present, as an empty array when the
+// board has no entries, e.g. at night.
+type departuresResponse struct {
+	StopID     string      `json:"stopId"`
+	Departures []Departure `json:"departures"`
+	boardMeta
+}
+
+type arrivalsResponse struct {
+	StopID   string      `json:"stopId"`
+	Arrivals []Departure `json:"arrivals"`
+	boardMeta
+}
+
+// newBoardResponse wraps the entries of board in the response body for
+// boardType.
+func newBoardResponse(boardType BoardType, stopID string, board *DepartureBoard) any {
+	entries := board.entries()
+	if entries == nil {
+		entries = []Departure{}
+	}
+	if boardType == BoardArrivals {
+		return arrivalsResponse{StopID: stopID, Arrivals: entries, boardMeta: board.Meta}
+	}
+	return departuresResponse{StopID: stopID, Departures: entries, boardMeta: board.Meta}
+}
+
+// entries returns the departures of a departure board or the arrivals of an
+// arrival board.
+func (b *DepartureBoard) entries() []Departure {
+	if b.Departures != nil {
+		return b.Departures
+	}
+	return b.Arrivals
+}
+
+// dedupe drops repeated entries, which RMV occasionally sends after merging
+// a real-time update, keeping the first of each. It returns the number of
+// entries removed.
+func (b *DepartureBoard) dedupe() int {
+	var departures, arrivals int
+	b.Departures, departures = dedupeDepartures(b.Departures)
+	b.Arrivals, arrivals = dedupeDepartures(b.Arrivals)
+	return departures + arrivals
+}
+
+// departureKey identifies an entry for dedupe: its line, direction or
+// origin, effective date and time as sent by RMV, and journey.
+type departureKey struct {
+	line, direction, origin, date, time, journey string
+}
+
+func dedupeDepartures(list []Departure) ([]Departure, int) {
+	seen := make(map[departureKey]bool, len(list))
+	out := list[:0]
+	for _, d := range list {
+		key := departureKey{line: d.line(), direction: d.Direction, origin: d.Origin, date: d.Date, time: d.Time}
+		if d.RtTime != "" {
+			key.time = d.RtTime
+			if d.RtDate != "" {
+				key.date = d.RtDate
+			}
+		}
+		if d.JourneyDetailRef != nil {
+			key.journey = d.JourneyDetailRef.Ref
+		}
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		out = append(out, d)
+	}
+	return out, len(list) - len(out)
+}
+
+// Departure is a single entry of a departure or arrival board.
+type Departure struct {
+	// Name is the line name as shown to passengers, e.g. "Tram 12".
+	Name string `json:"name"`
+	// Direction is set on departures, Origin on arrivals.
+	Direction string `json:"direction,omitempty"`
+	Origin    string `json:"origin,omitempty"`
+	// Stop is the name of the stop the departure belongs to.
+	Stop   string `json:"stop,omitempty"`
+	StopID string `json:"stopExtId,omitempty"`
+
+	// Date and Time are the scheduled departure (or arrival) as local
+	// wall-clock time ("2006-01-02", "15:04:05") without an offset. They are
+	// interpreted in the configured TIMEZONE.
+	Date string `json:"date"`
+	Time string `json:"time"`
+	// RtDate and RtTime carry the real-time prognosis in the same local
+	// time and are empty when no real-time data is available.
+	RtDate string `json:"rtDate,omitempty"`
+	RtTime string `json:"rtTime,omitempty"`
+
+	// Track is the scheduled platform, RtTrack the real-time one.
+	Track   string `json:"track,omitempty"`
+	RtTrack string `json:"rtTrack,omitempty"`
+
+	Product          Product           `json:"ProductAtStop"`
+	JourneyDetailRef *JourneyDetailRef `json:"JourneyDetailRef,omitempty"`
+
+	// The fields below are computed by this service when responding and
+	// are not part of the HAFAS response.
+
+	// Line is the short line label, see line.
+	Line string `json:"line"`
+
+	// MinutesUntil is the number of whole minutes from now until the
+	// effective departure time, read in the configured TIMEZONE.
+	MinutesUntil int `json:"minutesUntil"`
+	// EffectiveTimeLocal is the effective departure time as RFC 3339 with
+	// the configured TIMEZONE's offset, EffectiveTimeUTC the same instant
+	// in UTC. Both are empty when the RMV time cannot be parsed.
+	EffectiveTimeLocal string `json:"effectiveTime,omitempty"`
+	EffectiveTimeUTC   string `json:"effectiveTimeUtc,omitempty"`
+	// IsRealtime reports whether the effective time is a real-time prognosis.
+	IsRealtime bool `json:"isRealtime"`
+	// DelayMinutes is the real-time minus the scheduled time in whole
+	// minutes, negative when running early and 0 without real-time data.
+	DelayMinutes int `json:"delayMinutes"`
+}
+
+// line returns the short line label, e.g. "12", falling back to the full
+// name when RMV sends none.
+func (d Departure) line() string {
+	if d.Product.Line != "" {
+		return d.Product.Line
+	}
+	return d.Name
+}
+
+// Product describes the line and transport category serving a departure.
+type Product struct {
+	Name     string `json:"name"`
+	Line     string `json:"line,omitempty"`
+	CatOut   string `json:"catOut,omitempty"`
+	CatOutL  string `json:"catOutL,omitempty"`
+	CatCode  string `json:"catCode,omitempty"`
+	Operator string `json:"operator,omitempty"`
+}
+
+// JourneyDetailRef references the full trip of a departure.
+type JourneyDetailRef struct {
+	Ref string `json:"ref"`
+}
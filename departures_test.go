@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestEmptyBoardHasDeparturesArray(t *testing.T) {
+	for _, upstream := range []string{
+		`{"Departure":[],"requestId":"night"}`,
+		`{"requestId":"night"}`,
+	} {
+		for _, view := range viewNames() {
+			stub := newStubRMV(t, serveBody(http.StatusOK, []byte(upstream)))
+			server := newTestServer(t, testConfig(t, stub.URL), stub.client())
+
+			var body map[string]json.RawMessage
+			resp := getJSON(t, server.URL+"/next-departures?stopId=3000001&view="+view, &body)
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("%s, view %s: got status %d, want 200", upstream, view, resp.StatusCode)
+			}
+			if got := string(body["stopId"]); got != `"3000001"` {
+				t.Errorf("%s, view %s: got stopId %s, want \"3000001\"", upstream, view, got)
+			}
+			if got := string(body["departures"]); got != "[]" {
+				t.Errorf("%s, view %s: got departures %s, want []", upstream, view, got)
+			}
+		}
+	}
+}
+
+func TestDuplicateDeparturesAreCollapsed(t *testing.T) {
+	loc, err := loadLocation(defaultTimezone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := time.Now().Add(10 * time.Minute).In(loc)
+	rt := at.Add(2 * time.Minute)
+	entry := func(journey string) map[string]any {
+		return map[string]any{
+			"name":             "Tram 12",
+			"direction":        "Hauptbahnhof",
+			"date":             at.Format("2006-01-02"),
+			"time":             at.Format("15:04:05"),
+			"rtDate":           rt.Format("2006-01-02"),
+			"rtTime":           rt.Format("15:04:05"),
+			"ProductAtStop":    map[string]string{"name": "Tram 12", "line": "12", "catCode": "6"},
+			"JourneyDetailRef": map[string]string{"ref": journey},
+		}
+	}
+	upstream, err := json.Marshal(map[string]any{
+		// RMV repeats an entry after merging a real-time update; another
+		// journey at the same time is a different departure.
+		"Departure": []any{entry("journey-1"), entry("journey-1"), entry("journey-2")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stub := newStubRMV(t, serveBody(http.StatusOK, upstream))
+	server := newTestServer(t, testConfig(t, stub.URL), stub.client())
+
+	var body struct {
+		Departures []struct {
+			JourneyDetailRef struct {
+				Ref string `json:"ref"`
+			} `json:"JourneyDetailRef"`
+		} `json:"departures"`
+	}
+	if resp := getJSON(t, server.URL+"/next-departures?stopId=3000001", &body); resp.StatusCode != http.StatusOK {
+		t.Fatalf("got status %d, want 200", resp.StatusCode)
+	}
+	if len(body.Departures) != 2 {
+		t.Fatalf("got %d departures, want the duplicate collapsed into 2", len(body.Departures))
+	}
+	for i, want := range []string{"journey-1", "journey-2"} {
+		if got := body.Departures[i].JourneyDetailRef.Ref; got != want {
+			t.Errorf("departure %d: got journey %q, want %q", i, got, want)
+		}
+	}
+}
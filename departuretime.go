@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"time"
+)
+
+// parseDepartureTime parses the RMV date ("2006-01-02") and time ("15:04:05",
+// seconds optional) fields as a wall-clock time in loc.
+func parseDepartureTime(date, clock string, loc *time.Location) (time.Time, error) {
+	for _, layout := range []string{"2006-01-02 15:04:05", "2006-01-02 15:04"} {
+		if t, err := time.ParseInLocation(layout, date+" "+clock, loc); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid departure time %q %q", date, clock)
+}
+
+// ScheduledTime returns the planned departure time.
+func (d Departure) ScheduledTime(loc *time.Location) (time.Time, error) {
+	return parseDepartureTime(d.Date, d.Time, loc)
+}
+
+// EffectiveTime returns the real-time departure when available and the
+// scheduled one otherwise.
+//
+// Without an rtDate the prognosis is read on the scheduled date, except that
+// a real-time clock more than rolloverWindow before the scheduled one has
+// crossed midnight, e.g. scheduled 23:58 and expected 00:03, and is moved to
+// the next day.
+func (d Departure) EffectiveTime(loc *time.Location) (time.Time, error) {
+	if d.RtTime == "" {
+		return d.ScheduledTime(loc)
+	}
+	if d.RtDate != "" {
+		return parseDepartureTime(d.RtDate, d.RtTime, loc)
+	}
+	t, err := parseDepartureTime(d.Date, d.RtTime, loc)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if scheduled, err := d.ScheduledTime(loc); err == nil && scheduled.Sub(t) > rolloverWindow {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
+
+// rolloverWindow is how far a real-time clock may lie before the scheduled
+// one before it is taken to be on the next day. No train runs 12 hours early.
+const rolloverWindow = 12 * time.Hour
+
+// Delay returns the real-time minus the scheduled departure time. It is
+// negative for early departures and ok is false when there is no real-time
+// prognosis or a time cannot be parsed.
+func (d Departure) Delay(loc *time.Location) (delay time.Duration, ok bool) {
+	if d.RtTime == "" {
+		return 0, false
+	}
+	scheduled, err := d.ScheduledTime(loc)
+	if err != nil {
+		return 0, false
+	}
+	effective, err := d.EffectiveTime(loc)
+	if err != nil {
+		return 0, false
+	}
+	return effective.Sub(scheduled), true
+}
+
+// sortBoard returns a copy of board with its entries ordered by effective
+// time. Entries whose time cannot be parsed are moved to the end.
+func sortBoard(board *DepartureBoard, loc *time.Location) *DepartureBoard {
+	sorted := *board
+	sorted.Departures = sortDepartures(board.Departures, loc)
+	sorted.Arrivals = sortDepartures(board.Arrivals, loc)
+	return &sorted
+}
+
+func sortDepartures(list []Departure, loc *time.Location) []Departure {
+	if list == nil {
+		return nil
+	}
+	type keyed struct {
+		d  Departure
+		t  time.Time
+		ok bool
+	}
+	entries := make([]keyed, len(list))
+	for i, d := range list {
+		t, err := d.EffectiveTime(loc)
+		entries[i] = keyed{d: d, t: t, ok: err == nil}
+	}
+	slices.SortStableFunc(entries, func(a, b keyed) int {
+		if a.ok != b.ok {
+			if a.ok {
+				return -1
+			}
+			return 1
+		}
+		return a.t.Compare(b.t)
+	})
+	out := make([]Departure, len(entries))
+	for i, e := range entries {
+		out[i] = e.d
+	}
+	return out
+}
+
+// limitBoard returns board truncated to at most n entries; n <= 0 means no limit.
+func limitBoard(board *DepartureBoard, n int) *DepartureBoard {
+	if n <= 0 {
+		return board
+	}
+	limited := *board
+	limited.Departures = board.Departures[:min(n, len(board.Departures))]
+	limited.Arrivals = board.Arrivals[:min(n, len(board.Arrivals))]
+	return &limited
+}
+
+// enrichBoard returns a copy of board with the computed entry fields set.
+// Departed entries are dropped, or kept with MinutesUntil 0 if includeDeparted.
+func enrichBoard(board *DepartureBoard, loc *time.Location, now time.Time, includeDeparted bool) *DepartureBoard {
+	enriched := *board
+	enriched.Departures = enrichDepartures(board.Departures, loc, now, includeDeparted)
+	enriched.Arrivals = enrichDepartures(board.Arrivals, loc, now, includeDeparted)
+	return &enriched
+}
+
+func enrichDepartures(list []Departure, loc *time.Location, now time.Time, includeDeparted bool) []Departure {
+	if list == nil {
+		return nil
+	}
+	out := make([]Departure, 0, len(list))
+	for _, d := range list {
+		d.Line = d.line()
+		d.IsRealtime = d.RtTime != ""
+		d.MinutesUntil = 0
+		d.DelayMinutes = 0
+		d.EffectiveTimeLocal, d.EffectiveTimeUTC = "", ""
+		if delay, ok := d.Delay(loc); ok {
+			d.DelayMinutes = int(delay / time.Minute)
+		}
+		if t, err := d.EffectiveTime(loc); err == nil {
+			d.EffectiveTimeLocal = t.Format(time.RFC3339)
+			d.EffectiveTimeUTC = t.UTC().Format(time.RFC3339)
+			until := t.Sub(now)
+			if until < 0 && !includeDeparted {
+				continue
+			}
+			d.MinutesUntil = max(0, int(until/time.Minute))
+		}
+		out = append(out, d)
+	}
+	return out
+}
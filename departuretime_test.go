@@ -0,0 +1,81 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseDepartureTime(t *testing.T) {
+	loc, err := loadLocation(defaultTimezone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	valid := []struct{ date, clock, want string }{
+		{"2026-10-14", "07:05:30", "2026-10-14T07:05:30+02:00"},
+		{"2026-10-14", "07:05", "2026-10-14T07:05:00+02:00"},
+		{"2026-12-24", "23:59:59", "2026-12-24T23:59:59+01:00"},
+		{"2026-10-15", "00:00", "2026-10-15T00:00:00+02:00"},
+	}
+	for _, tc := range valid {
+		got, err := parseDepartureTime(tc.date, tc.clock, loc)
+		if err != nil {
+			t.Errorf("parseDepartureTime(%q, %q): %v", tc.date, tc.clock, err)
+			continue
+		}
+		if s := got.Format(time.RFC3339); s != tc.want {
+			t.Errorf("parseDepartureTime(%q, %q) = %s, want %s", tc.date, tc.clock, s, tc.want)
+		}
+	}
+
+	invalid := []struct{ date, clock string }{
+		{"", ""},
+		{"2026-10-14", ""},
+		{"", "07:05"},
+		{"2026-10-14", "24:00"},
+		{"2026-10-14", "07:60"},
+		{"2026-10-14", "07:05:60"},
+		{"2026-02-30", "07:05"},
+		{"14.10.2026", "07:05"},
+		{"2026-10-14", "7h05"},
+	}
+	for _, tc := range invalid {
+		if got, err := parseDepartureTime(tc.date, tc.clock, loc); err == nil {
+			t.Errorf("parseDepartureTime(%q, %q) = %v, want an error", tc.date, tc.clock, got)
+		}
+	}
+}
+
+func FuzzParseDepartureTime(f *testing.F) {
+	for _, seed := range [][2]string{
+		{"2026-10-14", "07:05:30"},
+		{"2026-10-14", "07:05"},
+		{"2026-10-14", "7:05"},
+		{"2026-10-14", "07:05:30.5"},
+		{"2026-10-14", "24:00"},
+		{"2026-02-29", "12:00"},
+		{"0000-01-01", "00:00"},
+		{"", ""},
+		{"2026-10-14 07:05", ""},
+	} {
+		f.Add(seed[0], seed[1])
+	}
+	f.Fuzz(func(t *testing.T, date, clock string) {
+		got, err := parseDepartureTime(date, clock, time.UTC)
+		if err != nil {
+			if !strings.HasPrefix(err.Error(), "invalid departure time") {
+				t.Errorf("unexpected error text %q", err)
+			}
+			return
+		}
+		// Whatever was accepted must survive a round trip through the
+		// canonical RMV format. time.Parse also takes fractional seconds.
+		again, err := parseDepartureTime(got.Format("2006-01-02"), got.Format("15:04:05.999999999"), time.UTC)
+		if err != nil {
+			t.Fatalf("canonical form of %q %q does not parse: %v", date, clock, err)
+		}
+		if !again.Equal(got) {
+			t.Errorf("%q %q parsed as %v, its canonical form as %v", date, clock, got, again)
+		}
+	})
+}
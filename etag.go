@@ -0,0 +1,76 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// weakETag derives a weak entity tag from a serialized response body, so
+// identical responses always carry the same tag.
+//
+// That requires the serialization of identical data to be identical. Board
+// bodies are encoded from structs and slices, whose order is fixed, and the
+// maps built by fieldsView are safe too: encoding/json writes map keys in
+// sorted order. Hand-rolled encoders must keep it that way.
+func weakETag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `W/"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// etagMatches reports whether an If-None-Match header value matches etag.
+// Comparison is weak as required for If-None-Match, so W/ prefixes are
+// ignored on both sides.
+func etagMatches(ifNoneMatch, etag string) bool {
+	if ifNoneMatch == "" {
+		return false
+	}
+	opaque := strings.TrimPrefix(etag, "W/")
+	for candidate := range strings.SplitSeq(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == opaque {
+			return true
+		}
+	}
+	return false
+}
+
+// notModifiedSince reports whether an If-Modified-Since header value is not
+// older than modified. HTTP dates have second precision, so modified is
+// truncated before comparing.
+func notModifiedSince(ifModifiedSince string, modified time.Time) bool {
+	if ifModifiedSince == "" || modified.IsZero() {
+		return false
+	}
+	since, err := http.ParseTime(ifModifiedSince)
+	if err != nil {
+		return false
+	}
+	return !modified.Truncate(time.Second).After(since)
+}
+
+// writeConditional writes body with ETag and Last-Modified headers, or a
+// bare 304 Not Modified when the request's validators show the client
+// already has it. As required by RFC 9110, If-Modified-Since is only
+// considered when there is no If-None-Match. Other headers must be set by
+// the caller beforehand.
+func writeConditional(w http.ResponseWriter, r *http.Request, body []byte, lastModified time.Time) {
+	etag := weakETag(body)
+	w.Header().Set("ETag", etag)
+	if !lastModified.IsZero() {
+		w.Header().Set("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+	}
+
+	notModified := etagMatches(r.Header.Get("If-None-Match"), etag)
+	if r.Header.Get("If-None-Match") == "" {
+		notModified = notModifiedSince(r.Header.Get("If-Modified-Since"), lastModified)
+	}
+	if notModified {
+		w.Header().Del("Content-Type")
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Write(body)
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestWeakETagStableForSameData(t *testing.T) {
+	var board DepartureBoard
+	if err := json.Unmarshal(boardJSON(time.Minute, 5*time.Minute), &board); err != nil {
+		t.Fatal(err)
+	}
+	fields, _ := parseFields("line,direction,minutesUntil")
+	for name, view := range map[string]Transformer{
+		"full":   views["full"],
+		"fields": fieldsView{view: views["full"], fields: fields},
+		"group":  TransformerFunc(groupByLine),
+	} {
+		var tags []string
+		for range 10 {
+			body, err := json.Marshal(view.Transform(BoardDepartures, "3000001", &board))
+			if err != nil {
+				t.Fatal(err)
+			}
+			tags = append(tags, weakETag(body))
+		}
+		for _, tag := range tags[1:] {
+			if tag != tags[0] {
+				t.Errorf("%s view: got ETags %s and %s for the same board", name, tags[0], tag)
+			}
+		}
+	}
+}
+
+func TestBoardETagStableAcrossRequests(t *testing.T) {
+	stub := newStubRMV(t, serveBoard(30*time.Minute))
+	config := testConfig(t, stub.URL)
+	server := newTestServer(t, config, stub.client())
+	url := server.URL + "/next-departures?stopId=3000001"
+
+	first := getJSON(t, url, nil).Header.Get("ETag")
+	// Long enough for anything counting down in seconds to change.
+	time.Sleep(1100 * time.Millisecond)
+	if second := getJSON(t, url, nil).Header.Get("ETag"); second != first {
+		t.Errorf("got ETags %s and %s for the same cached board", first, second)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("If-None-Match", first)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotModified {
+		t.Errorf("If-None-Match with the current ETag: got status %d, want 304", resp.StatusCode)
+	}
+}
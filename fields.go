@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+)
+
+// entryFieldNames are the JSON keys board entries can have in any view,
+// which are the names accepted by ?fields=.
+var entryFieldNames = jsonFieldNames(reflect.TypeFor[Departure](), reflect.TypeFor[compactEntry]())
+
+func jsonFieldNames(types ...reflect.Type) map[string]bool {
+	names := make(map[string]bool)
+	for _, t := range types {
+		for i := range t.NumField() {
+			name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+			if name != "" && name != "-" {
+				names[name] = true
+			}
+		}
+	}
+	return names
+}
+
+// parseFields turns a comma-separated ?fields= value into the set of entry
+// keys to keep, nil meaning all. Unknown names are dropped and returned so
+// the caller can report them; if no known name remains, all keys are kept
+// rather than none.
+func parseFields(raw string) (fields map[string]bool, unknown []string) {
+	names := splitList(raw)
+	if len(names) == 0 {
+		return nil, nil
+	}
+	fields = make(map[string]bool)
+	for _, name := range names {
+		if entryFieldNames[name] {
+			fields[name] = true
+		} else {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(fields) == 0 {
+		return nil, unknown
+	}
+	return fields, unknown
+}
+
+// fieldsView wraps a view and reduces every board entry of its output to
+// the selected keys. Top-level keys such as stopId are kept.
+type fieldsView struct {
+	view   Transformer
+	fields map[string]bool
+}
+
+func (v fieldsView) Transform(boardType BoardType, stopID string, board *DepartureBoard) any {
+	body := v.view.Transform(boardType, stopID, board)
+	data, err := json.Marshal(body)
+	if err != nil {
+		return body
+	}
+	var top map[string]json.RawMessage
+	if err := json.Unmarshal(data, &top); err != nil {
+		return body
+	}
+	for _, key := range []string{"departures", "arrivals"} {
+		var entries []map[string]json.RawMessage
+		if raw, ok := top[key]; !ok || json.Unmarshal(raw, &entries) != nil {
+			continue
+		}
+		for _, entry := range entries {
+			for name := range entry {
+				if !v.fields[name] {
+					delete(entry, name)
+				}
+			}
+		}
+		top[key], _ = json.Marshal(entries)
+	}
+	return top
+}
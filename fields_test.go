@@ -0,0 +1,33 @@
+package main
+
+import (
+	"maps"
+	"slices"
+	"testing"
+)
+
+func TestParseFields(t *testing.T) {
+	tests := []struct {
+		raw         string
+		wantFields  []string
+		wantUnknown []string
+	}{
+		{"", nil, nil},
+		{"line, minutesUntil", []string{"line", "minutesUntil"}, nil},
+		{"line,foo", []string{"line"}, []string{"foo"}},
+		// Only unknown names: no projection instead of empty entries.
+		{"foo,bar", nil, []string{"foo", "bar"}},
+	}
+	for _, tc := range tests {
+		fields, unknown := parseFields(tc.raw)
+		if got := slices.Sorted(maps.Keys(fields)); !slices.Equal(got, tc.wantFields) {
+			t.Errorf("parseFields(%q) fields = %v, want %v", tc.raw, got, tc.wantFields)
+		}
+		if tc.wantFields == nil && fields != nil {
+			t.Errorf("parseFields(%q) = empty non-nil set, want nil", tc.raw)
+		}
+		if !slices.Equal(unknown, tc.wantUnknown) {
+			t.Errorf("parseFields(%q) unknown = %v, want %v", tc.raw, unknown, tc.wantUnknown)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// productCategories maps the names accepted by ?products= to the HAFAS
+// product class codes (catCode) RMV reports for each departure.
+var productCategories = map[string][]string{
+	"longdistance": {"0", "1"},
+	"regional":     {"2", "3"},
+	"sbahn":        {"4"},
+	"ubahn":        {"5"},
+	"tram":         {"6"},
+	"bus":          {"7", "8"},
+	"ferry":        {"9"},
+	"ondemand":     {"10"},
+}
+
+func productNames() []string {
+	names := make([]string, 0, len(productCategories))
+	for name := range productCategories {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// boardProducts returns the sorted ?products= names of the entries on
+// board. Entries with a catCode outside productCategories are skipped.
+func boardProducts(board *DepartureBoard) []string {
+	codes := make(map[string]bool)
+	for _, d := range board.entries() {
+		codes[d.Product.CatCode] = true
+	}
+	products := []string{}
+	for _, name := range productNames() {
+		if slices.ContainsFunc(productCategories[name], func(c string) bool { return codes[c] }) {
+			products = append(products, name)
+		}
+	}
+	return products
+}
+
+// parseProducts turns a comma-separated ?products= value into the set of
+// accepted catCodes. An empty value yields a nil set, meaning no filtering.
+func parseProducts(raw string) (map[string]bool, error) {
+	names := splitList(raw)
+	if len(names) == 0 {
+		return nil, nil
+	}
+	codes := make(map[string]bool)
+	for _, name := range names {
+		cats, ok := productCategories[strings.ToLower(name)]
+		if !ok {
+			return nil, fmt.Errorf("unknown product %q, valid products are: %s", name, strings.Join(productNames(), ", "))
+		}
+		for _, c := range cats {
+			codes[c] = true
+		}
+	}
+	return codes, nil
+}
+
+// filterBoard returns a copy of board containing only the entries keep
+// accepts. The original, which may be shared through the cache, is not modified.
+func filterBoard(board *DepartureBoard, keep func(Departure) bool) *DepartureBoard {
+	filtered := *board
+	filtered.Departures = filterDepartures(board.Departures, keep)
+	filtered.Arrivals = filterDepartures(board.Arrivals, keep)
+	return &filtered
+}
+
+func filterDepartures(list []Departure, keep func(Departure) bool) []Departure {
+	if list == nil {
+		return nil
+	}
+	out := make([]Departure, 0, len(list))
+	for _, d := range list {
+		if keep(d) {
+			out = append(out, d)
+		}
+	}
+	return out
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"mime"
+	"strconv"
+	"strings"
+)
+
+// Response formats of the board endpoints, selected with ?format= or the
+// Accept header.
+const (
+	formatJSON = "json"
+	formatCSV  = "csv"
+	formatXML  = "xml"
+)
+
+var supportedFormats = []string{formatJSON, formatCSV, formatXML}
+
+// formatMediaTypes maps the media types understood in Accept to formats.
+var formatMediaTypes = map[string]string{
+	"application/json": formatJSON,
+	"text/csv":         formatCSV,
+	"application/xml":  formatXML,
+	"text/xml":         formatXML,
+}
+
+// negotiateFormat picks the format for an Accept header: the supported media
+// type with the highest non-zero q-value, the first listed on a tie. If none
+// is listed, it falls back to JSON, unless the header refuses JSON with q=0
+// for application/json or a wildcard covering it, in which case ok is false.
+func negotiateFormat(accept string) (format string, ok bool) {
+	format, best := formatJSON, 0.0
+	jsonQ, jsonExplicit := 1.0, false
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if raw, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(raw, 64); err != nil {
+				continue
+			}
+		}
+		switch mediaType {
+		case "application/json":
+			jsonQ, jsonExplicit = q, true
+		case "*/*", "application/*":
+			if !jsonExplicit {
+				jsonQ = q
+			}
+		}
+		if f, ok := formatMediaTypes[mediaType]; ok && q > best {
+			format, best = f, q
+		}
+	}
+	return format, best > 0 || jsonQ > 0
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBoardFormatNegotiation(t *testing.T) {
+	stub := newStubRMV(t, serveBoard(5*time.Minute))
+	server := newTestServer(t, testConfig(t, stub.URL), stub.client())
+
+	for _, tc := range []struct {
+		accept, format string
+		wantStatus     int
+		wantType       string
+	}{
+		{"", "", http.StatusOK, "application/json"},
+		{"*/*", "", http.StatusOK, "application/json"},
+		{"image/png", "", http.StatusOK, "application/json"},
+		{"application/json", "", http.StatusOK, "application/json"},
+		{"text/csv", "", http.StatusOK, "text/csv"},
+		{"application/xml", "", http.StatusOK, "application/xml"},
+		{"text/xml", "", http.StatusOK, "application/xml"},
+		{"application/json;q=0.5, text/csv", "", http.StatusOK, "text/csv"},
+		{"text/csv;q=0.2, application/xml;q=0.8", "", http.StatusOK, "application/xml"},
+		{"text/csv;q=0, application/json", "", http.StatusOK, "application/json"},
+		{"application/json;q=0", "", http.StatusNotAcceptable, "application/json"},
+		{"*/*;q=0", "", http.StatusNotAcceptable, "application/json"},
+		{"application/json;q=0, text/csv", "", http.StatusOK, "text/csv"},
+		// An explicit ?format= wins over the Accept header.
+		{"text/csv", "json", http.StatusOK, "application/json"},
+		{"application/json", "xml", http.StatusOK, "application/xml"},
+		{"application/json;q=0", "csv", http.StatusOK, "text/csv"},
+		{"", "yaml", http.StatusBadRequest, "application/json"},
+	} {
+		url := server.URL + "/next-departures?stopId=3000001"
+		if tc.format != "" {
+			url += "&format=" + tc.format
+		}
+		req, err := http.NewRequest(http.MethodGet, url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tc.accept != "" {
+			req.Header.Set("Accept", tc.accept)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tc.wantStatus {
+			t.Errorf("Accept %q, format %q: got status %d, want %d", tc.accept, tc.format, resp.StatusCode, tc.wantStatus)
+		}
+		if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, tc.wantType) {
+			t.Errorf("Accept %q, format %q: got Content-Type %q, want %s", tc.accept, tc.format, ct, tc.wantType)
+		}
+	}
+}
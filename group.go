@@ -0,0 +1,39 @@
+package main
+
+// lineGroup is one line of a grouped board: the line label, the minutes
+// until each of its departures, soonest first, and the entries themselves.
+type lineGroup struct {
+	Line         string      `json:"line"`
+	MinutesUntil []int       `json:"minutesUntil"`
+	Entries      []Departure `json:"departures"`
+}
+
+// groupedBoardResponse is the body of a board requested with ?groupBy=line:
+//
+//	{"stopId":"...","lines":[{"line":"12","minutesUntil":[3,15,27],"departures":[...]}]}
+//
+// Lines are ordered by their next departure. The entries are the same as in
+// the full view, so filters and limit apply before grouping.
+type groupedBoardResponse struct {
+	StopID string      `json:"stopId"`
+	Lines  []lineGroup `json:"lines"`
+	boardMeta
+}
+
+// groupByLine is the Transformer for ?groupBy=line. It expects entries that
+// are already sorted and enriched, as done by boardQuery.apply.
+func groupByLine(_ BoardType, stopID string, board *DepartureBoard) any {
+	resp := groupedBoardResponse{StopID: stopID, Lines: []lineGroup{}, boardMeta: board.Meta}
+	index := make(map[string]int)
+	for _, d := range board.entries() {
+		i, ok := index[d.Line]
+		if !ok {
+			i = len(resp.Lines)
+			index[d.Line] = i
+			resp.Lines = append(resp.Lines, lineGroup{Line: d.Line})
+		}
+		resp.Lines[i].MinutesUntil = append(resp.Lines[i].MinutesUntil, d.MinutesUntil)
+		resp.Lines[i].Entries = append(resp.Lines[i].Entries, d)
+	}
+	return resp
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"compress/gzip"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+var gzipWriters = sync.Pool{
+	New: func() any { return gzip.NewWriter(nil) },
+}
+
+// gzipMiddleware compresses responses for clients that accept gzip. Bodies
+// smaller than minSize bytes are sent as-is, since compressing them costs
+// more than it saves. Server-Sent Events streams (paths ending in /stream)
+// are never compressed so every event reaches the client immediately.
+func gzipMiddleware(next http.Handler, minSize int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/stream") || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Accept-Encoding")
+		if !acceptsGzip(r.Header.Get("Accept-Encoding")) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		gw := &gzipResponseWriter{ResponseWriter: w, minSize: minSize}
+		defer gw.close()
+		next.ServeHTTP(gw, r)
+	})
+}
+
+// acceptsGzip reports whether an Accept-Encoding header allows gzip.
+func acceptsGzip(header string) bool {
+	for part := range strings.SplitSeq(header, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		coding = strings.TrimSpace(coding)
+		if coding != "gzip" && coding != "*" {
+			continue
+		}
+		q := strings.ReplaceAll(params, " ", "")
+		return q != "q=0" && q != "q=0.0" && q != "q=0.00" && q != "q=0.000"
+	}
+	return false
+}
+
+// gzipResponseWriter buffers the start of a response until it is known to
+// reach minSize bytes, then switches to gzip. Responses that end below the
+// threshold are written uncompressed when the handler returns.
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	minSize int
+	status  int
+	buf     []byte
+	gz      *gzip.Writer
+	// plain is set once the response is committed uncompressed.
+	plain bool
+}
+
+func (w *gzipResponseWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+}
+
+func (w *gzipResponseWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	switch {
+	case w.gz != nil:
+		return w.gz.Write(b)
+	case w.plain:
+		return w.ResponseWriter.Write(b)
+	}
+
+	w.buf = append(w.buf, b...)
+	if len(w.buf) < w.minSize {
+		return len(b), nil
+	}
+	if err := w.commit(); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// commit sends the headers and the buffered body, compressed unless the
+// handler already chose an encoding, the response is an event stream or
+// the body is below the threshold.
+func (w *gzipResponseWriter) commit() error {
+	h := w.Header()
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if len(w.buf) < w.minSize || len(w.buf) == 0 || h.Get("Content-Encoding") != "" ||
+		strings.HasPrefix(h.Get("Content-Type"), "text/event-stream") {
+		w.plain = true
+		w.ResponseWriter.WriteHeader(w.status)
+		_, err := w.ResponseWriter.Write(w.buf)
+		w.buf = nil
+		return err
+	}
+
+	h.Set("Content-Encoding", "gzip")
+	h.Del("Content-Length")
+	w.ResponseWriter.WriteHeader(w.status)
+	w.gz = gzipWriters.Get().(*gzip.Writer)
+	w.gz.Reset(w.ResponseWriter)
+	_, err := w.gz.Write(w.buf)
+	w.buf = nil
+	return err
+}
+
+// Flush commits the response so far and pushes it to the client.
+func (w *gzipResponseWriter) Flush() {
+	if w.gz == nil && !w.plain {
+		w.commit()
+	}
+	if w.gz != nil {
+		w.gz.Flush()
+	}
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+func (w *gzipResponseWriter) close() {
+	if w.gz == nil && !w.plain {
+		if w.status == 0 {
+			// The handler wrote nothing; leave the implicit 200 to net/http.
+			return
+		}
+		w.commit()
+		return
+	}
+	if w.gz != nil {
+		w.gz.Close()
+		gzipWriters.Put(w.gz)
+		w.gz = nil
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// UpstreamStatus tracks the outcome of the most recent upstream calls so the
+// readiness probe can report on RMV connectivity without calling it itself.
+type UpstreamStatus struct {
+	mu          sync.RWMutex
+	lastSuccess time.Time
+	lastFailure time.Time
+	lastError   string
+}
+
+func (s *UpstreamStatus) RecordSuccess() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastSuccess = time.Now()
+}
+
+func (s *UpstreamStatus) RecordFailure(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastFailure = time.Now()
+	s.lastError = err.Error()
+}
+
+type readinessReport struct {
+	Status      string     `json:"status"`
+	APIKey      bool       `json:"apiKeyConfigured"`
+	LastSuccess *time.Time `json:"lastUpstreamSuccess,omitempty"`
+	LastFailure *time.Time `json:"lastUpstreamFailure,omitempty"`
+	LastError   string     `json:"lastUpstreamError,omitempty"`
+	// CircuitBreaker is the breaker state, omitted when it is disabled.
+	CircuitBreaker string `json:"circuitBreaker,omitempty"`
+	// Maintenance reports whether upstream calls are switched off.
+	Maintenance bool `json:"maintenance,omitempty"`
+}
+
+// Readiness reports "ok" when an API key is configured and the most recent
+// upstream call did not fail. A freshly started instance that has not talked
+// to RMV yet is considered ready.
+func (s *UpstreamStatus) Readiness(apiKeyConfigured bool) readinessReport {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	report := readinessReport{Status: "ok", APIKey: apiKeyConfigured}
+	if !s.lastSuccess.IsZero() {
+		t := s.lastSuccess
+		report.LastSuccess = &t
+	}
+	if !s.lastFailure.IsZero() {
+		t := s.lastFailure
+		report.LastFailure = &t
+		report.LastError = s.lastError
+	}
+
+	if !apiKeyConfigured || s.lastFailure.After(s.lastSuccess) {
+		report.Status = "unavailable"
+	}
+	return report
+}
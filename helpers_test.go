@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// stubRMV is an httptest.Server standing in for the RMV API. It counts the
+// requests it receives and answers them with the current handler.
+type stubRMV struct {
+	*httptest.Server
+	calls   atomic.Int64
+	handler atomic.Pointer[http.HandlerFunc]
+}
+
+func newStubRMV(t *testing.T, handler http.HandlerFunc) *stubRMV {
+	t.Helper()
+	s := &stubRMV{}
+	s.setHandler(handler)
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.calls.Add(1)
+		(*s.handler.Load())(w, r)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// setHandler replaces how the stub answers subsequent requests.
+func (s *stubRMV) setHandler(handler http.HandlerFunc) {
+	s.handler.Store(&handler)
+}
+
+// client returns an RMVClient for the stub that makes a single attempt.
+func (s *stubRMV) client() *RMVClient {
+	return &RMVClient{
+		HTTPClient: s.Client(),
+		APIKey:     "test-key",
+		BaseURL:    s.URL,
+		Retry:      RetryPolicy{MaxAttempts: 1},
+	}
+}
+
+// serveBody answers every request with status and body.
+func serveBody(status int, body []byte) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		_, _ = w.Write(body)
+	}
+}
+
+// peakTracker records how many requests a stub handles at once.
+type peakTracker struct {
+	mu       sync.Mutex
+	inFlight int
+	maxSeen  int
+}
+
+// serve wraps next, holding every request for hold so that concurrent
+// requests overlap.
+func (p *peakTracker) serve(hold time.Duration, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p.mu.Lock()
+		p.inFlight++
+		p.maxSeen = max(p.maxSeen, p.inFlight)
+		p.mu.Unlock()
+		defer func() {
+			p.mu.Lock()
+			p.inFlight--
+			p.mu.Unlock()
+		}()
+		time.Sleep(hold)
+		next(w, r)
+	}
+}
+
+// peak returns the most requests that were in flight at once.
+func (p *peakTracker) peak() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.maxSeen
+}
+
+// serveBoard answers every request with a departure board holding one
+// departure per offset from now.
+func serveBoard(offsets ...time.Duration) http.HandlerFunc {
+	return serveBody(http.StatusOK, boardJSON(offsets...))
+}
+
+// boardJSON returns a departureBoard response with one tram departure per
+// offset from now, with times in Europe/Berlin like RMV sends them.
+func boardJSON(offsets ...time.Duration) []byte {
+	loc, err := loadLocation(defaultTimezone)
+	if err != nil {
+		panic(err)
+	}
+	deps := []map[string]any{}
+	for i, offset := range offsets {
+		t := time.Now().Add(offset).In(loc)
+		deps = append(deps, map[string]any{
+			"name":             "Tram 12",
+			"direction":        "Hauptbahnhof",
+			"date":             t.Format("2006-01-02"),
+			"time":             t.Format("15:04:05"),
+			"ProductAtStop":    map[string]string{"name": "Tram 12", "line": "12", "catCode": "6"},
+			"JourneyDetailRef": map[string]string{"ref": "journey-" + strconv.Itoa(i)},
+		})
+	}
+	body, err := json.Marshal(map[string]any{"Departure": deps, "requestId": "stub"})
+	if err != nil {
+		panic(err)
+	}
+	return body
+}
+
+// testConfig loads the configuration for a service talking to baseURL, with
+// further environment variables given as name/value pairs.
+func testConfig(t *testing.T, baseURL string, env ...string) Config {
+	t.Helper()
+	t.Setenv("RMV_API_KEY", "test-key")
+	t.Setenv("RMV_BASE_URL", baseURL)
+	t.Setenv("STOP_ID", "3000001")
+	t.Setenv("RATE_LIMIT_RPS", "1000")
+	t.Setenv("RATE_LIMIT_BURST", "1000")
+	for i := 0; i+1 < len(env); i += 2 {
+		t.Setenv(env[i], env[i+1])
+	}
+	config, err := LoadConfig()
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	return config
+}
+
+// newTestServer serves the full handler chain of newHandler with an
+// in-memory cache and without background refresh.
+func newTestServer(t *testing.T, config Config, client *RMVClient) *httptest.Server {
+	t.Helper()
+	shutdown := make(chan struct{})
+	t.Cleanup(func() { close(shutdown) })
+	cache := NewMemoryCache(0, config.StaleWindow)
+	limiter := newIPRateLimiter(config.RateLimitRPS, config.RateLimitBurst)
+	server := httptest.NewServer(newHandler(shutdown, config, cache, client, &UpstreamStatus{}, limiter, nil))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// getJSON requests url and decodes the JSON response into dst, returning
+// the response with its body consumed.
+func getJSON(t *testing.T, url string, dst any) *http.Response {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("GET %s: %v", url, err)
+	}
+	defer resp.Body.Close()
+	if dst != nil {
+		if err := json.NewDecoder(resp.Body).Decode(dst); err != nil {
+			t.Fatalf("GET %s: decoding response: %v", url, err)
+		}
+	}
+	return resp
+}
+
+// advance moves the clock of cache forward by d.
+func advance(cache *MemoryCache, d time.Duration) {
+	now := cache.now
+	cache.now = func() time.Time { return now().Add(d) }
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/url"
+)
+
+// JourneyDetail mirrors the journeyDetail JSON of the RMV HAFAS API: the
+// stop-by-stop route of a single trip.
+type JourneyDetail struct {
+	Stops      JourneyStops `json:"Stops"`
+	Directions *struct {
+		Direction []struct {
+			Value string `json:"value"`
+		} `json:"Direction"`
+	} `json:"Directions,omitempty"`
+	RequestID string `json:"requestId,omitempty"`
+}
+
+type JourneyStops struct {
+	Stop []JourneyStop `json:"Stop"`
+}
+
+// JourneyStop is one stop along a journey. Arrival fields are empty for the
+// first stop and departure fields for the last one. Times are local
+// wall-clock times like on Departure.
+type JourneyStop struct {
+	Name     string  `json:"name"`
+	ID       string  `json:"extId"`
+	RouteIdx int     `json:"routeIdx"`
+	Lon      float64 `json:"lon,omitempty"`
+	Lat      float64 `json:"lat,omitempty"`
+
+	ArrDate   string `json:"arrDate,omitempty"`
+	ArrTime   string `json:"arrTime,omitempty"`
+	RtArrDate string `json:"rtArrDate,omitempty"`
+	RtArrTime string `json:"rtArrTime,omitempty"`
+
+	DepDate   string `json:"depDate,omitempty"`
+	DepTime   string `json:"depTime,omitempty"`
+	RtDepDate string `json:"rtDepDate,omitempty"`
+	RtDepTime string `json:"rtDepTime,omitempty"`
+
+	Track   string `json:"track,omitempty"`
+	RtTrack string `json:"rtTrack,omitempty"`
+}
+
+// Journey fetches the journeyDetail for a JourneyDetailRef.
+func (c *RMVClient) Journey(ctx context.Context, ref string) (*JourneyDetail, error) {
+	params := url.Values{}
+	params.Set("id", ref)
+
+	var journey JourneyDetail
+	if err := c.call(ctx, "journeyDetail", params, &journey); err != nil {
+		return nil, err
+	}
+	return &journey, nil
+}
+
+// fetchJourney returns the journey for ref, cached for the journey TTL.
+func fetchJourney(ctx context.Context, cache Cache, client *RMVClient, config Config, ref string) (*JourneyDetail, error) {
+	cacheKey := "journey:" + ref
+	if item, ok := cache.Get(ctx, cacheKey); ok {
+		var journey JourneyDetail
+		if err := json.Unmarshal(item.Data, &journey); err == nil {
+			cacheRequestsTotal.WithLabelValues("hit").Inc()
+			return &journey, nil
+		}
+	}
+	cacheRequestsTotal.WithLabelValues("miss").Inc()
+
+	journey, err := client.Journey(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	if data, err := json.Marshal(journey); err == nil {
+		cache.Set(ctx, cacheKey, data, config.CacheTTLs.For(ttlJourney))
+	}
+	slog.InfoContext(ctx, "fetched journey", "ref", ref)
+	return journey, nil
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// defaultLang is the language of RMV responses unless a request asks for
+// another one.
+const defaultLang = "de"
+
+// supportedLangs are the values of the HAFAS lang parameter offered to
+// clients.
+var supportedLangs = []string{"de", "en", "fr", "es", "it", "nl", "pl", "da"}
+
+// parseLang picks the response language: ?lang= if given, which must be
+// supported, else the supported language ranked highest in the
+// Accept-Language header, else defaultLang. Regional variants such as
+// "en-GB" map to their base language.
+func parseLang(param, acceptLanguage string) (string, *requestError) {
+	if param != "" {
+		lang := strings.ToLower(param)
+		if !slices.Contains(supportedLangs, lang) {
+			return "", badParameter("lang must be one of %s", strings.Join(supportedLangs, ", "))
+		}
+		return lang, nil
+	}
+	lang, best := defaultLang, 0.0
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		base, _, _ := strings.Cut(strings.ToLower(strings.TrimSpace(tag)), "-")
+		if !slices.Contains(supportedLangs, base) {
+			continue
+		}
+		q := 1.0
+		if raw, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			var err error
+			if q, err = strconv.ParseFloat(raw, 64); err != nil {
+				continue
+			}
+		}
+		if q > best {
+			lang, best = base, q
+		}
+	}
+	return lang, nil
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/url"
+	"slices"
+	"strconv"
+)
+
+// StopLocation is a stop as returned by the HAFAS location services. ID is
+// the external ID accepted by ?stopId= on the board endpoints.
+type StopLocation struct {
+	ID   string  `json:"extId"`
+	Name string  `json:"name"`
+	Lon  float64 `json:"lon"`
+	Lat  float64 `json:"lat"`
+	// Dist is the distance in meters, set by location.nearbystops only.
+	Dist int `json:"dist,omitempty"`
+}
+
+// locationList mirrors the HAFAS location response, which mixes stops with
+// addresses and points of interest.
+type locationList struct {
+	Locations []struct {
+		StopLocation *StopLocation `json:"StopLocation"`
+	} `json:"stopLocationOrCoordLocation"`
+}
+
+// stopResult is a stop in the responses of the /stops/... endpoints.
+type stopResult struct {
+	ID       string  `json:"id"`
+	Name     string  `json:"name"`
+	Lat      float64 `json:"lat"`
+	Lon      float64 `json:"lon"`
+	Distance int     `json:"distanceMeters,omitempty"`
+}
+
+// SearchStops looks up stops whose name matches query via location.name.
+func (c *RMVClient) SearchStops(ctx context.Context, query string) ([]stopResult, error) {
+	params := url.Values{}
+	params.Set("input", query)
+	params.Set("type", "S")
+	params.Set("maxNo", "20")
+	return c.locations(ctx, "location.name", params)
+}
+
+// NearbyStops looks up stops within radius meters of a coordinate via
+// location.nearbystops, nearest first.
+func (c *RMVClient) NearbyStops(ctx context.Context, lat, lon float64, radius int) ([]stopResult, error) {
+	params := url.Values{}
+	params.Set("originCoordLat", strconv.FormatFloat(lat, 'f', -1, 64))
+	params.Set("originCoordLong", strconv.FormatFloat(lon, 'f', -1, 64))
+	params.Set("r", strconv.Itoa(radius))
+	params.Set("type", "S")
+	params.Set("maxNo", "50")
+	stops, err := c.locations(ctx, "location.nearbystops", params)
+	if err != nil {
+		return nil, err
+	}
+	slices.SortStableFunc(stops, func(a, b stopResult) int { return cmp.Compare(a.Distance, b.Distance) })
+	return stops, nil
+}
+
+// locations calls a HAFAS location service and returns the stops in its
+// response, in the upstream's order.
+func (c *RMVClient) locations(ctx context.Context, service string, params url.Values) ([]stopResult, error) {
+	var list locationList
+	if err := c.call(ctx, service, params, &list); err != nil {
+		return nil, err
+	}
+	stops := make([]stopResult, 0, len(list.Locations))
+	for _, loc := range list.Locations {
+		if s := loc.StopLocation; s != nil {
+			stops = append(stops, stopResult{ID: s.ID, Name: s.Name, Lat: s.Lat, Lon: s.Lon, Distance: s.Dist})
+		}
+	}
+	return stops, nil
+}
+
+// fetchStops returns the stops cached under key, calling fetch and caching
+// its result for the location TTL on a miss.
+func fetchStops(ctx context.Context, cache Cache, config Config, key string, fetch func(context.Context) ([]stopResult, error)) ([]stopResult, error) {
+	if item, ok := cache.Get(ctx, key); ok {
+		var stops []stopResult
+		if err := json.Unmarshal(item.Data, &stops); err == nil {
+			cacheRequestsTotal.WithLabelValues("hit").Inc()
+			return stops, nil
+		}
+	}
+	cacheRequestsTotal.WithLabelValues("miss").Inc()
+
+	stops, err := fetch(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if data, err := json.Marshal(stops); err == nil {
+		cache.Set(ctx, key, data, config.CacheTTLs.For(ttlSearch))
+	}
+	slog.InfoContext(ctx, "fetched stop locations", "key", key, "count", len(stops))
+	return stops, nil
+}
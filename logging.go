@@ -0,0 +1,117 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// setupLogging installs the default slog logger. format is "text" (default)
+// or "json"; level is one of debug, info (default), warn or error. Unknown
+// values are reported and replaced by the defaults.
+func setupLogging(format, level string) {
+	var lvl slog.Level
+	levelErr := lvl.UnmarshalText([]byte(cmp.Or(level, "info")))
+	if levelErr != nil {
+		lvl = slog.LevelInfo
+	}
+
+	opts := &slog.HandlerOptions{Level: lvl}
+	var handler slog.Handler
+	switch strings.ToLower(format) {
+	case "json":
+		handler = slog.NewJSONHandler(os.Stderr, opts)
+	default:
+		handler = slog.NewTextHandler(os.Stderr, opts)
+	}
+	slog.SetDefault(slog.New(&contextHandler{Handler: handler}))
+
+	if levelErr != nil {
+		slog.Warn("invalid LOG_LEVEL, using info", "value", level)
+	}
+	if format != "" && !strings.EqualFold(format, "json") && !strings.EqualFold(format, "text") {
+		slog.Warn("invalid LOG_FORMAT, using text", "value", format)
+	}
+}
+
+type requestIDKey struct{}
+
+// requestIDFromContext returns the request ID set by accessLogMiddleware, if any.
+func requestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// contextHandler adds the request ID found in the context to every record,
+// so calls like slog.InfoContext(r.Context(), ...) are correlated automatically.
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h *contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id := requestIDFromContext(ctx); id != "" {
+		r.AddAttrs(slog.String("requestId", id))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h *contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &contextHandler{Handler: h.Handler.WithAttrs(attrs)}
+}
+
+func (h *contextHandler) WithGroup(name string) slog.Handler {
+	return &contextHandler{Handler: h.Handler.WithGroup(name)}
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// validRequestID accepts client-supplied IDs that are short and printable,
+// so they are safe to echo back and to write to logs.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 64 {
+		return false
+	}
+	for _, c := range id {
+		if c < '!' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// accessLogMiddleware assigns each request an ID, echoes it as X-Request-ID
+// and logs one line per request once it has been served.
+func accessLogMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		id := r.Header.Get("X-Request-ID")
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set("X-Request-ID", id)
+		ctx := context.WithValue(r.Context(), requestIDKey{}, id)
+
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r.WithContext(ctx))
+
+		slog.InfoContext(ctx, "request",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", rec.code(r),
+			"bytes", rec.bytes,
+			"duration", time.Since(start),
+			"clientIp", clientIP(r),
+		)
+	})
+}
@@ -1,198 +1,275 @@
 package main
 
 import (
+	"cmp"
 	"context"
 	"encoding/json"
-	"fmt"
-	"io"
+	"errors"
+	"flag"
 	"log/slog"
+	"net"
 	"net/http"
-	"net/url"
 	"os"
-	"slices"
-	"strings"
+	"os/signal"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/joho/godotenv"
+	"golang.org/x/sync/semaphore"
+	"golang.org/x/time/rate"
 )
 
-type Config struct {
-	APIKey         string
-	StopID         string
-	Port           string
-	AllowedOrigins []string
-}
+func main() {
+	check := flag.Bool("check", false, "validate the configuration and RMV connectivity, then exit (also CHECK_ONLY=true)")
+	flag.Parse()
 
-type cacheEntry struct {
-	data      any
-	expiresAt time.Time
-}
+	_ = godotenv.Load()
 
-type Cache struct {
-	mu      sync.RWMutex
-	entries map[string]cacheEntry
-}
+	setupLogging(os.Getenv("LOG_FORMAT"), os.Getenv("LOG_LEVEL"))
 
-func NewCache() *Cache {
-	return &Cache{
-		entries: make(map[string]cacheEntry),
-	}
-}
+	build := currentBuildInfo()
+	slog.Info("build info", "version", build.Version, "commit", build.Commit, "buildTime", build.BuildTime, "goVersion", build.GoVersion)
 
-func (c *Cache) Get(key string) (any, bool) {
-	c.mu.RLock()
-	defer c.mu.RUnlock()
-	entry, ok := c.entries[key]
-	if !ok || time.Now().After(entry.expiresAt) {
-		return nil, false
+	config, err := LoadConfig()
+	if *check || envBool("CHECK_ONLY", false) {
+		if !runCheck(context.Background(), os.Stdout, config, err) {
+			os.Exit(1)
+		}
+		return
 	}
-	return entry.data, true
-}
-
-func (c *Cache) Set(key string, data any, ttl time.Duration) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	c.entries[key] = cacheEntry{
-		data:      data,
-		expiresAt: time.Now().Add(ttl),
+	if err != nil {
+		slog.Error("invalid configuration", "error", err)
+		os.Exit(1)
 	}
-}
 
-func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
 
-	_ = godotenv.Load()
+	shutdownTracing, err := setupTracing(ctx)
+	if err != nil {
+		slog.Error("failed to set up tracing", "error", err)
+		os.Exit(1)
+	}
 
-	allowedOriginsRaw := os.Getenv("ALLOWED_ORIGINS")
-	var allowedOrigins []string
-	if allowedOriginsRaw != "" {
-		for _, o := range strings.Split(allowedOriginsRaw, ",") {
-			if trimmed := strings.TrimSpace(o); trimmed != "" {
-				allowedOrigins = append(allowedOrigins, trimmed)
-			}
+	var background sync.WaitGroup
+
+	var cache Cache
+	if config.CacheBackend == "redis" {
+		redisCache, err := NewRedisCache(config.RedisURL, config.CachePrefix, config.StaleWindow)
+		if err != nil {
+			slog.Error("invalid REDIS_URL", "error", err)
+			os.Exit(1)
+		}
+		defer redisCache.Close()
+		pingCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		if err := redisCache.Ping(pingCtx); err != nil {
+			slog.Warn("redis is not reachable, requests will go to the upstream until it is", "error", err)
 		}
+		cancel()
+		cache = redisCache
+	} else {
+		memoryCache := NewMemoryCache(config.CacheMaxEntries, config.StaleWindow)
+		registerCacheMetrics(memoryCache)
+		if config.CacheCleanupInterval > 0 {
+			background.Go(func() { memoryCache.RunJanitor(ctx, config.CacheCleanupInterval) })
+		}
+		cache = memoryCache
 	}
-
-	config := Config{
-		APIKey:         os.Getenv("RMV_API_KEY"),
-		StopID:         os.Getenv("STOP_ID"),
-		Port:           os.Getenv("PORT"),
-		AllowedOrigins: allowedOrigins,
+	slog.Info("using cache backend", "backend", config.CacheBackend)
+
+	status := &UpstreamStatus{}
+	client := &RMVClient{
+		HTTPClient:       &http.Client{Transport: newUpstreamTransport(config.Transport)},
+		APIKey:           config.APIKey,
+		BaseURL:          config.BaseURL,
+		UserAgent:        config.UserAgent,
+		Retry:            config.Retry,
+		Timeout:          config.UpstreamTimeout,
+		MaxResponseBytes: config.UpstreamMaxResponseBytes,
+		Status:           status,
 	}
-
-	if config.Port == "" {
-		config.Port = "8080"
+	if config.MaintenanceMode {
+		client.Maintenance.Store(true)
+		slog.Warn("maintenance mode active, serving cached data only")
 	}
-
-	if config.APIKey == "" {
-		slog.Error("RMV_API_KEY environment variable is required")
-		os.Exit(1)
+	if config.UpstreamRPS > 0 {
+		client.Limiter = rate.NewLimiter(rate.Limit(config.UpstreamRPS), max(1, config.UpstreamBurst))
 	}
-	if config.StopID == "" {
-		slog.Error("STOP_ID environment variable is required")
-		os.Exit(1)
+	if config.BreakerThreshold > 0 {
+		client.Breaker = NewCircuitBreaker(config.BreakerThreshold, config.BreakerCooldown)
+		registerBreakerMetrics(client.Breaker)
+	}
+	if config.MaxConcurrentUpstream > 0 {
+		client.Concurrency = semaphore.NewWeighted(int64(config.MaxConcurrentUpstream))
 	}
 
-	mux := http.NewServeMux()
-	cache := NewCache()
+	limiter := newIPRateLimiter(config.RateLimitRPS, config.RateLimitBurst)
+	background.Go(func() { limiter.cleanup(ctx, time.Minute, 10*time.Minute) })
 
-	// Handler for next departures
-	mux.HandleFunc("GET /next-departures", func(w http.ResponseWriter, r *http.Request) {
-		departures, err := fetchDepartures(r.Context(), cache, config.APIKey, config.StopID)
-		if err != nil {
-			slog.Error("failed to fetch departures", "error", err)
-			http.Error(w, "Failed to fetch departures", http.StatusInternalServerError)
-			return
+	var refresher *Refresher
+	if config.RefreshInterval > 0 {
+		refresher = NewRefresher(config.RefreshInterval, func(ctx context.Context, t refreshTarget) error {
+			_, err := refreshBoard(ctx, cache, client, config, t.StopID, t.Duration, t.Lang, t.Board)
+			if errors.Is(err, ErrMaintenance) {
+				return nil
+			}
+			return err
+		})
+		for _, stopID := range config.keptStops() {
+			refresher.Keep(refreshTarget{Board: BoardDepartures, StopID: stopID, Duration: defaultDuration, Lang: defaultLang})
 		}
+		background.Go(func() { refresher.Run(ctx) })
+	}
 
-		w.Header().Set("Content-Type", "application/json")
-		if err := json.NewEncoder(w).Encode(departures); err != nil {
-			slog.Error("failed to encode departures", "error", err)
-		}
-	})
+	server := &http.Server{
+		Addr:    net.JoinHostPort(config.BindAddress, config.Port),
+		Handler: newHandler(ctx.Done(), config, cache, client, status, limiter, refresher),
+	}
+
+	if config.WarmCache && !config.MaintenanceMode {
+		warmCtx, cancel := context.WithTimeout(ctx, config.RequestTimeout)
+		warmCache(warmCtx, cache, client, config)
+		cancel()
+	}
 
-	// Optional: proxy for the raw departureBoard endpoint if desired,
-	// but the requirement says "the created endpoint should list the next departures for a tram stop"
-	// and "Only for the departureBoard Endpoint".
-	// I'll stick to the specific "next-departures" as requested.
+	serverErr := make(chan error, 1)
+	go func() {
+		tls := config.TLSCertFile != ""
+		slog.Info("Starting server", "addr", server.Addr, "tls", tls, "stopId", config.StopID, "timezone", config.Location)
+		if tls {
+			serverErr <- server.ListenAndServeTLS(config.TLSCertFile, config.TLSKeyFile)
+		} else {
+			serverErr <- server.ListenAndServe()
+		}
+	}()
 
-	addr := ":" + config.Port
-	slog.Info("Starting server", "addr", addr, "stopId", config.StopID)
-	handler := corsMiddleware(mux, config.AllowedOrigins)
-	if err := http.ListenAndServe(addr, handler); err != nil {
+	select {
+	case err := <-serverErr:
 		slog.Error("server failed", "error", err)
 		os.Exit(1)
+	case <-ctx.Done():
+	}
+	// Restore default signal handling so a second signal terminates at once.
+	stop()
+
+	slog.Info("shutting down, draining connections", "timeout", config.ShutdownTimeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), config.ShutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		slog.Error("graceful shutdown failed", "error", err)
+		os.Exit(1)
 	}
+	background.Wait()
+	if err := shutdownTracing(shutdownCtx); err != nil {
+		slog.Warn("failed to flush traces", "error", err)
+	}
+	slog.Info("server stopped")
 }
 
-func corsMiddleware(next http.Handler, allowedOrigins []string) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		origin := r.Header.Get("Origin")
-		if origin != "" && (slices.Contains(allowedOrigins, origin) || slices.Contains(allowedOrigins, "*")) {
-			w.Header().Set("Access-Control-Allow-Origin", origin)
-			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
-		}
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("failed to encode response", "error", err)
+	}
+}
 
-		if r.Method == http.MethodOptions {
-			w.WriteHeader(http.StatusNoContent)
-			return
-		}
+// errorBody is the JSON envelope for all error responses:
+//
+//	{"error":{"code":"upstream_error","message":"..."}}
+type errorBody struct {
+	Error errorDetail `json:"error"`
+}
 
-		next.ServeHTTP(w, r)
-	})
+type errorDetail struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
 }
 
-func fetchDepartures(ctx context.Context, cache *Cache, apiKey, stopID string) (any, error) {
-	cacheKey := stopID
-	if data, ok := cache.Get(cacheKey); ok {
-		slog.Info("cache hit", "stopId", stopID)
-		return data, nil
+// writeFetchError reports a failed upstream fetch of resource as described
+// at fetchError. Nothing is written if the client has already disconnected.
+func writeFetchError(w http.ResponseWriter, r *http.Request, err error, resource string, attrs ...any) {
+	if errors.Is(r.Context().Err(), context.Canceled) {
+		// The client went away; there is no one to send an error to.
+		slog.DebugContext(r.Context(), "client disconnected during fetch", append(attrs, "resource", resource)...)
+		return
 	}
+	setUpstreamRequestID(w, upstreamRequestID(err))
+	status, detail := fetchError(r.Context(), err, resource, attrs...)
+	writeJSON(w, status, errorBody{Error: detail})
+}
 
-	u, err := url.Parse("https://www.rmv.de/hapi/departureBoard")
-	if err != nil {
-		return nil, err
+// fetchError maps a failed upstream fetch of resource to a response status
+// and error. A request that found no free upstream request slot in time
+// gets a 503 upstream_busy, one that otherwise ran into its deadline a 503,
+// one made in maintenance mode a 503 with code maintenance, a request RMV
+// rejected as invalid a 400 upstream_rejected, a rejected API key a 502 with
+// code upstream_auth_failed, an undecodable response a 502
+// upstream_decode_error, a call refused by a rate limit a 503
+// upstream_rate_limited, a call held back by the circuit breaker a 503
+// upstream_unavailable and anything else a 502 upstream_error. Only the
+// error text of an RMV error payload is passed on; other details are logged
+// together with attrs but not sent to the client.
+func fetchError(ctx context.Context, err error, resource string, attrs ...any) (int, errorDetail) {
+	if id := upstreamRequestID(err); id != "" {
+		attrs = append(attrs, "upstreamRequestId", id)
 	}
-
-	q := u.Query()
-	q.Set("accessId", apiKey)
-	q.Set("id", stopID)
-	q.Set("format", "json")
-	q.Set("duration", "60")
-	u.RawQuery = q.Encode()
-
-	client := &http.Client{
-		Timeout: 10 * time.Second,
+	if errors.Is(err, ErrUpstreamBusy) {
+		slog.WarnContext(ctx, "no free upstream request slot for "+resource, append(attrs, "error", err)...)
+		return http.StatusServiceUnavailable, errorDetail{Code: "upstream_busy", Message: "Too many requests to the upstream API in flight, try again later"}
 	}
-
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
-	if err != nil {
-		return nil, err
+	if errors.Is(err, context.DeadlineExceeded) && ctx.Err() != nil {
+		// The deadline is ours, not an upstream failure: note it quietly.
+		slog.DebugContext(ctx, "request timed out", append(attrs, "resource", resource, "error", err)...)
+		return http.StatusServiceUnavailable, errorDetail{Code: "timeout", Message: "Request timed out"}
 	}
-
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, err
+	if errors.Is(err, ErrMaintenance) {
+		slog.InfoContext(ctx, "maintenance mode, nothing cached for "+resource, attrs...)
+		return http.StatusServiceUnavailable, errorDetail{Code: "maintenance", Message: "The service is in maintenance and has no cached " + resource}
 	}
-	defer func(Body io.ReadCloser) {
-		err := Body.Close()
-		if err != nil {
-			slog.Error("failed to close response body", "error", err)
+	if rateLimited(err) {
+		slog.WarnContext(ctx, "upstream rate limit reached, not fetching "+resource, append(attrs, "error", err)...)
+		return http.StatusServiceUnavailable, errorDetail{Code: "upstream_rate_limited", Message: "Too many requests to the upstream API, try again later"}
+	}
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		if apiErr.InvalidRequest() {
+			slog.WarnContext(ctx, "upstream rejected request", append(attrs, "resource", resource, "error", err)...)
+			return http.StatusBadRequest, errorDetail{Code: "upstream_rejected", Message: cmp.Or(apiErr.Text, apiErr.Code)}
 		}
-	}(resp.Body)
-
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("API returned status %d", resp.StatusCode)
+		slog.ErrorContext(ctx, "failed to fetch "+resource, append(attrs, "error", err)...)
+		return http.StatusBadGateway, errorDetail{Code: "upstream_error", Message: "Failed to fetch " + resource + ": " + cmp.Or(apiErr.Text, apiErr.Code)}
 	}
-
-	var data any
-	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
-		return nil, err
+	var decodeErr *DecodeError
+	if errors.As(err, &decodeErr) {
+		slog.ErrorContext(ctx, "failed to decode "+resource, append(attrs, "error", err)...)
+		return http.StatusBadGateway, errorDetail{Code: "upstream_decode_error", Message: "The upstream API sent an unreadable response for " + resource}
+	}
+	if errors.Is(err, ErrCircuitOpen) {
+		slog.WarnContext(ctx, "upstream circuit open, not fetching "+resource, attrs...)
+		return http.StatusServiceUnavailable, errorDetail{Code: "upstream_unavailable", Message: "The upstream API is failing, try again later"}
+	}
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) && statusErr.AuthFailed() {
+		slog.ErrorContext(ctx, "upstream credentials rejected", append(attrs, "resource", resource, "error", err)...)
+		return http.StatusBadGateway, errorDetail{Code: "upstream_auth_failed", Message: "The upstream API rejected this service's credentials"}
 	}
+	slog.ErrorContext(ctx, "failed to fetch "+resource, append(attrs, "error", err)...)
+	return http.StatusBadGateway, errorDetail{Code: "upstream_error", Message: "Failed to fetch " + resource}
+}
 
-	cache.Set(cacheKey, data, 5*time.Minute)
-	slog.Info("fetched new data", "stopId", stopID)
+// setUpstreamRequestID passes RMV's identifier of the call behind a response
+// on as X-Upstream-Request-ID, for support requests to RMV.
+func setUpstreamRequestID(w http.ResponseWriter, id string) {
+	if id != "" {
+		w.Header().Set("X-Upstream-Request-ID", id)
+	}
+}
 
-	return data, nil
+// writeJSONError writes an error envelope. The message is shown to clients,
+// so callers must not pass internal error details for 5xx responses; log
+// those instead.
+func writeJSONError(w http.ResponseWriter, status int, code, message string) {
+	writeJSON(w, status, errorBody{Error: errorDetail{Code: code, Message: message}})
 }
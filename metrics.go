@@ -0,0 +1,87 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	httpRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "rmv_http_requests_total",
+		Help: "Total HTTP requests handled, by route and status code.",
+	}, []string{"route", "method", "code"})
+
+	cacheRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "rmv_cache_requests_total",
+		Help: "Board cache lookups, by result (hit, miss or stale).",
+	}, []string{"result"})
+
+	coalescedRequestsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "rmv_coalesced_requests_total",
+		Help: "Board fetches that shared another request's in-flight upstream call instead of making their own.",
+	})
+
+	upstreamDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "rmv_upstream_request_duration_seconds",
+		Help:    "Duration of requests to the RMV API.",
+		Buckets: prometheus.DefBuckets,
+	})
+
+	upstreamErrorsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "rmv_upstream_errors_total",
+		Help: "Failed requests to the RMV API, by status code (\"network\" for transport errors) or RMV error code.",
+	}, []string{"code"})
+)
+
+func metricsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+
+		// r.Pattern is filled in by the mux; using it instead of the raw path
+		// keeps label cardinality bounded.
+		route := r.Pattern
+		if route == "" {
+			route = "unmatched"
+		}
+		httpRequestsTotal.WithLabelValues(route, r.Method, strconv.Itoa(rec.code(r))).Inc()
+	})
+}
+
+func observeUpstream(start time.Time, code string) {
+	upstreamDuration.Observe(time.Since(start).Seconds())
+	if code != "" {
+		upstreamErrorsTotal.WithLabelValues(code).Inc()
+	}
+}
+
+func registerBreakerMetrics(breaker *CircuitBreaker) {
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "rmv_upstream_circuit_open",
+		Help: "State of the upstream circuit breaker: 0 closed, 1 open, 0.5 half-open.",
+	}, func() float64 {
+		switch breaker.State() {
+		case breakerOpen:
+			return 1
+		case breakerHalfOpen:
+			return 0.5
+		}
+		return 0
+	})
+}
+
+func registerCacheMetrics(cache *MemoryCache) {
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "rmv_cache_entries",
+		Help: "Number of entries currently held in the board cache.",
+	}, func() float64 { return float64(cache.Len()) })
+
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "rmv_cache_capacity",
+		Help: "Maximum number of entries in the board cache (0 means unlimited).",
+	}, func() float64 { return float64(cache.Cap()) })
+}
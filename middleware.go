@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"errors"
+	"log/slog"
+	"maps"
+	"net/http"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+)
+
+// timeoutGrace is how long timeoutMiddleware waits past the deadline before
+// cutting a handler off, so one that notices the deadline can still answer,
+// e.g. with stale data.
+const timeoutGrace = time.Second
+
+// timeoutMiddleware bounds every request with a context deadline. Handlers
+// observe it through r.Context() and the upstream call is cancelled with it,
+// so a hanging RMV request cannot pile up goroutines. Like
+// http.TimeoutHandler, it buffers the response and sends a 503 with code
+// timeout instead if the handler has not returned timeoutGrace after the
+// deadline.
+//
+// Server-Sent Events streams (paths ending in /stream) are long-lived by
+// design and are exempt.
+func timeoutMiddleware(next http.Handler, timeout time.Duration) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/stream") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+
+		tw := &timeoutWriter{header: make(http.Header)}
+		done := make(chan struct{})
+		panicked := make(chan any, 1)
+		go func() {
+			defer func() {
+				if v := recover(); v != nil {
+					panicked <- v
+				}
+			}()
+			next.ServeHTTP(tw, r.WithContext(ctx))
+			close(done)
+		}()
+
+		cutoff := time.NewTimer(timeout + timeoutGrace)
+		defer cutoff.Stop()
+		select {
+		case v := <-panicked:
+			// Re-raised here so recoverMiddleware sees it.
+			panic(v)
+		case <-done:
+			tw.flush(w)
+		case <-r.Context().Done():
+			// The client went away; there is no one to answer.
+			tw.abandon()
+		case <-cutoff.C:
+			if tw.abandon() {
+				slog.WarnContext(r.Context(), "handler ignored its deadline, cut off", "method", r.Method, "path", r.URL.Path, "timeout", timeout)
+				writeJSONError(w, http.StatusServiceUnavailable, "timeout", "Request timed out")
+			}
+		}
+	})
+}
+
+// timeoutWriter buffers a handler's response for timeoutMiddleware. Once
+// abandoned, further writes fail with http.ErrHandlerTimeout.
+type timeoutWriter struct {
+	header http.Header
+
+	mu        sync.Mutex
+	status    int
+	body      bytes.Buffer
+	abandoned bool
+	flushed   bool
+}
+
+func (tw *timeoutWriter) Header() http.Header { return tw.header }
+
+func (tw *timeoutWriter) WriteHeader(code int) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.status == 0 {
+		tw.status = code
+	}
+}
+
+func (tw *timeoutWriter) Write(b []byte) (int, error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.abandoned {
+		return 0, http.ErrHandlerTimeout
+	}
+	if tw.status == 0 {
+		tw.status = http.StatusOK
+	}
+	return tw.body.Write(b)
+}
+
+// flush sends the buffered response to w.
+func (tw *timeoutWriter) flush(w http.ResponseWriter) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	maps.Copy(w.Header(), tw.header)
+	w.WriteHeader(cmp.Or(tw.status, http.StatusOK))
+	_, _ = w.Write(tw.body.Bytes())
+	tw.flushed = true
+}
+
+// abandon discards the response, reporting whether it had not been sent.
+func (tw *timeoutWriter) abandon() bool {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	tw.abandoned = true
+	return !tw.flushed
+}
+
+// recoverMiddleware turns a panicking handler into a 500 response instead of
+// a crashed process. The panic is logged with its stack trace; if the
+// handler already started the response, the connection is closed instead of
+// appending an error to a partial body.
+func recoverMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := &statusRecorder{ResponseWriter: w}
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			if v == http.ErrAbortHandler {
+				panic(v)
+			}
+			slog.ErrorContext(r.Context(), "handler panicked", "panic", v, "method", r.Method, "path", r.URL.Path, "stack", string(debug.Stack()))
+			if rec.status != 0 {
+				panic(http.ErrAbortHandler)
+			}
+			writeJSONError(w, http.StatusInternalServerError, "internal_error", "Internal server error")
+		}()
+		next.ServeHTTP(rec, r)
+	})
+}
+
+// statusRecorder captures the status code and body size written by a handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	if r.status == 0 {
+		r.status = code
+	}
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	n, err := r.ResponseWriter.Write(b)
+	r.bytes += n
+	return n, err
+}
+
+// statusClientClosed is the non-standard status (as used by nginx) recorded
+// for requests whose client went away before a response was written.
+const statusClientClosed = 499
+
+// code returns the status sent for req: the recorded one, 499 when nothing
+// was written because the client disconnected, and the implicit 200
+// otherwise.
+func (r *statusRecorder) code(req *http.Request) int {
+	switch {
+	case r.status != 0:
+		return r.status
+	case errors.Is(req.Context().Err(), context.Canceled):
+		return statusClientClosed
+	default:
+		return http.StatusOK
+	}
+}
+
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
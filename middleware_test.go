@@ -0,0 +1,97 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRecoverMiddleware(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /panic", func(w http.ResponseWriter, r *http.Request) {
+		panic("handler bug")
+	})
+	mux.HandleFunc("GET /partial", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, `{"departures":[`)
+		panic("handler bug")
+	})
+	mux.HandleFunc("GET /ok", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]bool{"ok": true})
+	})
+	server := httptest.NewServer(recoverMiddleware(mux))
+	defer server.Close()
+
+	var body errorBody
+	resp := getJSON(t, server.URL+"/panic", &body)
+	if resp.StatusCode != http.StatusInternalServerError {
+		t.Errorf("got status %d, want 500", resp.StatusCode)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "application/json" {
+		t.Errorf("got Content-Type %q, want application/json", ct)
+	}
+	if body.Error.Code != "internal_error" {
+		t.Errorf("got code %q, want internal_error", body.Error.Code)
+	}
+
+	// A started response cannot become a 500; the connection is cut instead.
+	if resp, err := http.Get(server.URL + "/partial"); err == nil {
+		_, err = io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err == nil {
+			t.Error("panic after writing: got a complete response, want the connection closed")
+		}
+	}
+
+	if resp := getJSON(t, server.URL+"/ok", nil); resp.StatusCode != http.StatusOK {
+		t.Errorf("after panics: got status %d, want 200", resp.StatusCode)
+	}
+}
+
+func TestTimeoutMiddleware(t *testing.T) {
+	const timeout = 50 * time.Millisecond
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /ok", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Test", "yes")
+		writeJSON(w, http.StatusTeapot, map[string]bool{"ok": true})
+	})
+	mux.HandleFunc("GET /ignores-deadline", func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(timeout + timeoutGrace + time.Second)
+		writeJSON(w, http.StatusOK, map[string]bool{"ok": true})
+	})
+	mux.HandleFunc("GET /honours-deadline", func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+		writeJSON(w, http.StatusOK, map[string]bool{"stale": true})
+	})
+	mux.HandleFunc("GET /panic", func(w http.ResponseWriter, r *http.Request) {
+		panic("handler bug")
+	})
+	server := httptest.NewServer(recoverMiddleware(timeoutMiddleware(mux, timeout)))
+	defer server.Close()
+
+	resp := getJSON(t, server.URL+"/ok", nil)
+	if resp.StatusCode != http.StatusTeapot || resp.Header.Get("X-Test") != "yes" {
+		t.Errorf("fast handler: got status %d, X-Test %q, want 418 and yes", resp.StatusCode, resp.Header.Get("X-Test"))
+	}
+
+	var body errorBody
+	start := time.Now()
+	resp = getJSON(t, server.URL+"/ignores-deadline", &body)
+	if resp.StatusCode != http.StatusServiceUnavailable || body.Error.Code != "timeout" {
+		t.Errorf("handler ignoring its deadline: got status %d, code %q, want 503 timeout", resp.StatusCode, body.Error.Code)
+	}
+	if elapsed := time.Since(start); elapsed > timeout+timeoutGrace+500*time.Millisecond {
+		t.Errorf("handler ignoring its deadline was cut off after %v", elapsed)
+	}
+
+	// A handler answering on its deadline, e.g. with stale data, gets through.
+	if resp := getJSON(t, server.URL+"/honours-deadline", nil); resp.StatusCode != http.StatusOK {
+		t.Errorf("handler honouring its deadline: got status %d, want 200", resp.StatusCode)
+	}
+
+	body = errorBody{}
+	if resp := getJSON(t, server.URL+"/panic", &body); resp.StatusCode != http.StatusInternalServerError || body.Error.Code != "internal_error" {
+		t.Errorf("panic: got status %d, code %q, want 500 internal_error", resp.StatusCode, body.Error.Code)
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// routePattern matches the routes registered in newHandler, e.g.
+// mux.HandleFunc("GET /health", ...).
+var routePattern = regexp.MustCompile(`mux\.Handle(?:Func)?\("([A-Z]+) (/[^"]*)"`)
+
+func TestOpenAPIDocumentsAllRoutes(t *testing.T) {
+	var spec struct {
+		Paths map[string]map[string]json.RawMessage `json:"paths"`
+	}
+	if err := json.Unmarshal(openAPISpec, &spec); err != nil {
+		t.Fatalf("openapi.json: %v", err)
+	}
+	src, err := os.ReadFile("routes.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	routes := routePattern.FindAllStringSubmatch(string(src), -1)
+	if len(routes) == 0 {
+		t.Fatal("found no routes in routes.go")
+	}
+	for _, route := range routes {
+		method, path := strings.ToLower(route[1]), route[2]
+		if _, ok := spec.Paths[path][method]; !ok {
+			t.Errorf("%s %s is registered but missing from openapi.json", route[1], path)
+		}
+	}
+}
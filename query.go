@@ -0,0 +1,310 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// requestError is a client error detected while parsing a request.
+type requestError struct {
+	Status  int
+	Code    string
+	Message string
+}
+
+func (e *requestError) Error() string {
+	return e.Message
+}
+
+func badParameter(format string, args ...any) *requestError {
+	return &requestError{Status: http.StatusBadRequest, Code: "invalid_parameter", Message: fmt.Sprintf(format, args...)}
+}
+
+func writeRequestError(w http.ResponseWriter, err *requestError) {
+	writeJSONError(w, err.Status, err.Code, err.Message)
+}
+
+// Bounds and default of the radius parameter of /stops/nearby, in meters.
+const (
+	defaultNearbyRadius = 1000
+	maxNearbyRadius     = 5000
+)
+
+// nearbyQuery holds the parsed parameters of /stops/nearby.
+type nearbyQuery struct {
+	Lat, Lon float64
+	Radius   int
+}
+
+// parseNearbyQuery reads lat, lon and radius. The coordinates are rounded to
+// three decimals (about 100 m) so that requests from close-by clients share
+// one cache entry; distances are relative to the rounded point.
+func parseNearbyQuery(r *http.Request) (nearbyQuery, *requestError) {
+	q := nearbyQuery{Radius: defaultNearbyRadius}
+	params := r.URL.Query()
+
+	lat, err := strconv.ParseFloat(params.Get("lat"), 64)
+	if err != nil || math.IsNaN(lat) || lat < -90 || lat > 90 {
+		return q, badParameter("lat must be a number between -90 and 90")
+	}
+	lon, err := strconv.ParseFloat(params.Get("lon"), 64)
+	if err != nil || math.IsNaN(lon) || lon < -180 || lon > 180 {
+		return q, badParameter("lon must be a number between -180 and 180")
+	}
+	q.Lat = math.Round(lat*1000) / 1000
+	q.Lon = math.Round(lon*1000) / 1000
+
+	if raw := params.Get("radius"); raw != "" {
+		radius, err := strconv.Atoi(raw)
+		if err != nil || radius < 1 || radius > maxNearbyRadius {
+			return q, badParameter("radius must be an integer between 1 and %d meters", maxNearbyRadius)
+		}
+		q.Radius = radius
+	}
+	return q, nil
+}
+
+// tripQuery holds the parsed parameters of /trip. Date and Time are local
+// ("2006-01-02", "15:04") and empty for departures from now on.
+type tripQuery struct {
+	From, To   string
+	Date, Time string
+}
+
+// tripTimeLayouts are the accepted forms of ?time=: a local date and time,
+// or an RFC 3339 timestamp with offset.
+var tripTimeLayouts = []string{"2006-01-02T15:04", time.RFC3339}
+
+// parseTripQuery reads from, to and the optional time of a trip search.
+func parseTripQuery(r *http.Request, loc *time.Location) (tripQuery, *requestError) {
+	params := r.URL.Query()
+	q := tripQuery{From: strings.TrimSpace(params.Get("from")), To: strings.TrimSpace(params.Get("to"))}
+	if q.From == "" || q.To == "" {
+		return q, badParameter("from and to are required")
+	}
+	if raw := params.Get("time"); raw != "" {
+		var t time.Time
+		var err error
+		for _, layout := range tripTimeLayouts {
+			if t, err = time.ParseInLocation(layout, raw, loc); err == nil {
+				break
+			}
+		}
+		if err != nil {
+			return q, badParameter("time must look like 2006-01-02T15:04 or be an RFC 3339 timestamp")
+		}
+		t = t.In(loc)
+		q.Date, q.Time = t.Format("2006-01-02"), t.Format("15:04")
+	}
+	return q, nil
+}
+
+// boardQuery holds the parsed query parameters of the board endpoints.
+type boardQuery struct {
+	StopID   string
+	Duration int
+	// Lang is the language of names and messages, see parseLang.
+	Lang string
+	// Products is the set of accepted catCodes, nil when not filtering.
+	Products map[string]bool
+	// Limit caps the number of entries returned; zero means no limit.
+	Limit int
+	// IncludeDeparted keeps entries whose departure time has passed.
+	IncludeDeparted bool
+	// Direction, when set, keeps only entries whose direction (origin on
+	// arrival boards) contains it as a case-insensitive substring. It is
+	// stored lower-cased.
+	Direction string
+	// MinDelay, when set, keeps only entries with real-time data that are
+	// delayed by at least that many minutes.
+	MinDelay *int
+	// Fresh asks for data no older than Config.FreshMaxAge instead of
+	// anything within the cache TTL.
+	Fresh bool
+	// NoServiceMessage, when set, marks responses with serviceActive and
+	// adds it as message to empty ones; see boardMeta.
+	NoServiceMessage string
+	// Cursor, when set, continues a paged board; see boardCursor.
+	Cursor *boardCursor
+	// Format is the response format, one of supportedFormats.
+	Format string
+	// View shapes JSON responses; see views. With ?fields= it only keeps
+	// the selected keys of each entry, with ?groupBy=line it is
+	// groupByLine.
+	View Transformer
+}
+
+// parseBoardQuery reads the board query parameters from the request,
+// falling back to the configured defaults.
+func parseBoardQuery(r *http.Request, config Config) (boardQuery, *requestError) {
+	q := boardQuery{StopID: config.StopID, Duration: defaultDuration, Limit: config.DefaultLimit, Format: formatJSON, NoServiceMessage: config.NoServiceMessage}
+	params := r.URL.Query()
+
+	if raw := params.Get("duration"); raw != "" {
+		d, err := strconv.Atoi(raw)
+		if err != nil || d < minDuration || d > maxDuration {
+			return q, badParameter("duration must be an integer between %d and %d", minDuration, maxDuration)
+		}
+		q.Duration = d
+	}
+
+	if name := params.Get("stop"); name != "" {
+		id, ok := config.lookupStop(name)
+		if !ok {
+			return q, &requestError{Status: http.StatusNotFound, Code: "unknown_stop", Message: fmt.Sprintf("no stop named %q is configured", name)}
+		}
+		q.StopID = id
+	}
+	if id := params.Get("stopId"); id != "" {
+		if !config.stopAllowed(id) {
+			return q, &requestError{Status: http.StatusForbidden, Code: "stop_not_allowed", Message: "stop is not allowed"}
+		}
+		q.StopID = id
+	}
+
+	lang, reqErr := parseLang(params.Get("lang"), r.Header.Get("Accept-Language"))
+	if reqErr != nil {
+		return q, reqErr
+	}
+	q.Lang = lang
+
+	products, err := parseProducts(params.Get("products"))
+	if err != nil {
+		return q, badParameter("%s", err)
+	}
+	q.Products = products
+
+	if raw := params.Get("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 {
+			return q, badParameter("limit must be a positive integer")
+		}
+		q.Limit = n
+	}
+
+	if raw := params.Get("includeDeparted"); raw != "" {
+		include, err := strconv.ParseBool(raw)
+		if err != nil {
+			return q, badParameter("includeDeparted must be true or false")
+		}
+		q.IncludeDeparted = include
+	}
+
+	q.Direction = strings.ToLower(strings.TrimSpace(params.Get("direction")))
+
+	if raw := params.Get("fresh"); raw != "" {
+		fresh, err := strconv.ParseBool(raw)
+		if err != nil {
+			return q, badParameter("fresh must be true or false")
+		}
+		q.Fresh = fresh
+	}
+
+	if raw := params.Get("minDelay"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 0 {
+			return q, badParameter("minDelay must be a non-negative integer")
+		}
+		q.MinDelay = &n
+	}
+
+	if raw := params.Get("cursor"); raw != "" {
+		cursor, reqErr := parseCursor(raw)
+		if reqErr != nil {
+			return q, reqErr
+		}
+		q.Cursor = cursor
+	}
+
+	view, reqErr := parseView(params.Get("view"))
+	if reqErr != nil {
+		return q, reqErr
+	}
+	q.View = view
+	fields, unknown := parseFields(params.Get("fields"))
+	if len(unknown) > 0 {
+		slog.WarnContext(r.Context(), "ignoring unknown fields", "fields", unknown)
+	}
+	if fields != nil {
+		q.View = fieldsView{view: view, fields: fields}
+	}
+	switch params.Get("groupBy") {
+	case "":
+	case "line":
+		if params.Get("view") != "" || fields != nil {
+			return q, badParameter("groupBy cannot be combined with view or fields")
+		}
+		q.View = TransformerFunc(groupByLine)
+	default:
+		return q, badParameter("groupBy must be line")
+	}
+
+	if raw := params.Get("format"); raw != "" {
+		if !slices.Contains(supportedFormats, raw) {
+			return q, badParameter("format must be one of %s", strings.Join(supportedFormats, ", "))
+		}
+		q.Format = raw
+	}
+
+	return q, nil
+}
+
+// maxAge is the age beyond which a cached board is refreshed for this
+// query, for fetchBoardMaxAge: Config.FreshMaxAge with ?fresh=, else zero.
+func (q boardQuery) maxAge(config Config) time.Duration {
+	if q.Fresh {
+		return config.FreshMaxAge
+	}
+	return 0
+}
+
+// apply runs the post-fetch filters selected by the query, orders the
+// entries by effective departure time, skips to the cursor, computes the
+// relative fields against now and finally applies the limit. When the limit
+// cut entries off, NextOffset of the result is where the next page starts.
+func (q boardQuery) apply(board *DepartureBoard, loc *time.Location, now time.Time) *DepartureBoard {
+	if q.Products != nil {
+		board = filterBoard(board, func(d Departure) bool { return q.Products[d.Product.CatCode] })
+	}
+	if q.Direction != "" {
+		board = filterBoard(board, func(d Departure) bool {
+			return strings.Contains(strings.ToLower(cmp.Or(d.Direction, d.Origin)), q.Direction)
+		})
+	}
+	if q.MinDelay != nil {
+		minDelay := time.Duration(*q.MinDelay) * time.Minute
+		board = filterBoard(board, func(d Departure) bool {
+			delay, ok := d.Delay(loc)
+			return ok && delay >= minDelay
+		})
+	}
+	board = sortBoard(board, loc)
+	var offset int
+	if q.Cursor != nil {
+		offset = q.Cursor.Offset
+		board = skipBoard(board, offset)
+	}
+	enriched := enrichBoard(board, loc, now, q.IncludeDeparted)
+	limited := limitBoard(enriched, q.Limit)
+	if n := len(limited.entries()); n < len(enriched.entries()) {
+		// enrichBoard only drops departed entries, which sort first, so the
+		// page consumed those plus the n entries it returns.
+		limited.NextOffset = offset + len(board.entries()) - len(enriched.entries()) + n
+	}
+	if q.NoServiceMessage != "" {
+		// limited is a copy made by enrichBoard, so it may be modified.
+		active := len(limited.entries()) > 0
+		limited.Meta.ServiceActive = &active
+		if !active {
+			limited.Meta.Message = q.NoServiceMessage
+		}
+	}
+	return limited
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// ipRateLimiter hands out one token bucket per client IP.
+type ipRateLimiter struct {
+	mu      sync.Mutex
+	rps     rate.Limit
+	burst   int
+	clients map[string]*clientLimiter
+}
+
+type clientLimiter struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+func newIPRateLimiter(rps float64, burst int) *ipRateLimiter {
+	return &ipRateLimiter{
+		rps:     rate.Limit(rps),
+		burst:   burst,
+		clients: make(map[string]*clientLimiter),
+	}
+}
+
+func (l *ipRateLimiter) get(ip string) *rate.Limiter {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	c, ok := l.clients[ip]
+	if !ok {
+		c = &clientLimiter{limiter: rate.NewLimiter(l.rps, l.burst)}
+		l.clients[ip] = c
+	}
+	c.lastSeen = time.Now()
+	return c.limiter
+}
+
+// cleanup drops buckets of clients not seen for longer than idle, every interval,
+// until ctx is cancelled.
+func (l *ipRateLimiter) cleanup(ctx context.Context, interval, idle time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			l.mu.Lock()
+			for ip, c := range l.clients {
+				if time.Since(c.lastSeen) > idle {
+					delete(l.clients, ip)
+				}
+			}
+			l.mu.Unlock()
+		}
+	}
+}
+
+func rateLimitMiddleware(next http.Handler, limiter *ipRateLimiter) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		res := limiter.get(clientIP(r)).Reserve()
+		if delay := res.Delay(); delay > 0 {
+			res.Cancel()
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(delay.Seconds()))))
+			writeJSONError(w, http.StatusTooManyRequests, "rate_limited", "rate limit exceeded")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// refreshTarget identifies one board kept warm by the Refresher.
+type refreshTarget struct {
+	Board    BoardType
+	StopID   string
+	Duration int
+	Lang     string
+}
+
+// Refresher periodically re-fetches hot boards in the background so
+// that requests are served from cache instead of waiting for the upstream.
+//
+// Permanent targets are refreshed for the lifetime of the process. Targets
+// added via Touch are dropped once they have not been requested for idleAfter.
+type Refresher struct {
+	mu        sync.Mutex
+	targets   map[refreshTarget]time.Time // last requested, zero for permanent targets
+	interval  time.Duration
+	idleAfter time.Duration
+	refresh   func(ctx context.Context, t refreshTarget) error
+}
+
+func NewRefresher(interval time.Duration, refresh func(ctx context.Context, t refreshTarget) error) *Refresher {
+	return &Refresher{
+		targets:   make(map[refreshTarget]time.Time),
+		interval:  interval,
+		idleAfter: 3 * interval,
+		refresh:   refresh,
+	}
+}
+
+// Keep registers a target that is refreshed until shutdown.
+func (r *Refresher) Keep(t refreshTarget) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.targets[t] = time.Time{}
+}
+
+// Touch marks a target as recently requested, adding it if necessary.
+func (r *Refresher) Touch(t refreshTarget) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if last, ok := r.targets[t]; ok && last.IsZero() {
+		return
+	}
+	r.targets[t] = time.Now()
+}
+
+// Run refreshes all targets every interval until ctx is cancelled.
+func (r *Refresher) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			slog.Info("cache refresher stopped")
+			return
+		case <-ticker.C:
+			for _, t := range r.due() {
+				if ctx.Err() != nil {
+					break
+				}
+				if err := r.refresh(ctx, t); err != nil && ctx.Err() == nil {
+					slog.Warn("background refresh failed", "board", t.Board, "stopId", t.StopID, "duration", t.Duration, "error", err)
+				}
+			}
+		}
+	}
+}
+
+// due returns the targets to refresh and forgets the ones that went idle.
+func (r *Refresher) due() []refreshTarget {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var due []refreshTarget
+	for t, last := range r.targets {
+		if !last.IsZero() && time.Since(last) > r.idleAfter {
+			delete(r.targets, t)
+			continue
+		}
+		due = append(due, t)
+	}
+	return due
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestRefresherStopsOnCancel(t *testing.T) {
+	before := runtime.NumGoroutine()
+	started := make(chan struct{}, 1)
+	r := NewRefresher(time.Millisecond, func(ctx context.Context, _ refreshTarget) error {
+		select {
+		case started <- struct{}{}:
+		default:
+		}
+		// Hang like a slow upstream until the refresh is cancelled.
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	r.Keep(refreshTarget{Board: BoardDepartures, StopID: "3000001"})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stopped := make(chan struct{})
+	go func() {
+		r.Run(ctx)
+		close(stopped)
+	}()
+
+	select {
+	case <-started:
+	case <-time.After(time.Second):
+		t.Fatal("refresher did not refresh its target")
+	}
+	cancel()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("refresher did not stop within 1s of cancellation")
+	}
+
+	// The goroutine running Run may still be exiting after closing stopped.
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if n := runtime.NumGoroutine(); n > before {
+		t.Errorf("got %d goroutines after the refresher stopped, want at most %d", n, before)
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"time"
+)
+
+// RetryPolicy controls how often and how patiently upstream calls are retried.
+type RetryPolicy struct {
+	MaxAttempts int
+	BaseBackoff time.Duration
+	// sleep waits for d or until ctx is done; tests override it to record
+	// the backoff delays. Nil means a timer.
+	sleep func(ctx context.Context, d time.Duration) error
+}
+
+// StatusError is returned when the RMV API answers with a non-200 status.
+type StatusError struct {
+	StatusCode int
+	// RequestID is RMV's identifier of the failed call, if it sent one.
+	RequestID string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("API returned status %d", e.StatusCode)
+}
+
+// AuthFailed reports whether RMV rejected our credentials.
+func (e *StatusError) AuthFailed() bool {
+	return e.StatusCode == http.StatusUnauthorized || e.StatusCode == http.StatusForbidden
+}
+
+// withRetry calls fn until it succeeds, returns a non-retryable error or the
+// policy's attempts are used up. It never sleeps past the context deadline.
+func withRetry(ctx context.Context, policy RetryPolicy, fn func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= policy.MaxAttempts || ctx.Err() != nil || !retryable(err) {
+			return err
+		}
+
+		delay := backoff(policy.BaseBackoff, attempt)
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+			return err
+		}
+
+		slog.WarnContext(ctx, "upstream call failed, retrying", "attempt", attempt, "delay", delay, "error", err)
+		sleep := policy.sleep
+		if sleep == nil {
+			sleep = sleepContext
+		}
+		if sleep(ctx, delay) != nil {
+			return err
+		}
+	}
+}
+
+// sleepContext waits for d, returning early with ctx's error if it is done
+// first.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// retryable reports whether err is worth another attempt. Upstream 5xx
+// responses and transport errors, including a single attempt running into
+// the upstream timeout, are retried; 4xx responses and calls that found no
+// free request slot are not.
+func retryable(err error) bool {
+	if errors.Is(err, ErrUpstreamBusy) {
+		return false
+	}
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode >= 500
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var netErr interface{ Timeout() bool }
+	return errors.As(err, &netErr)
+}
+
+// backoff returns the delay before the given retry: base * 2^(attempt-1),
+// with half of it randomized to spread out concurrent retries.
+func backoff(base time.Duration, attempt int) time.Duration {
+	d := base << (attempt - 1)
+	if d <= 0 {
+		return 0
+	}
+	half := d / 2
+	return half + rand.N(half+1)
+}
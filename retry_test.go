@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+func TestBackoffBounds(t *testing.T) {
+	base := 100 * time.Millisecond
+	for attempt := 1; attempt <= 5; attempt++ {
+		full := base << (attempt - 1)
+		for range 100 {
+			if d := backoff(base, attempt); d < full/2 || d > full {
+				t.Fatalf("backoff(%v, %d) = %v, want between %v and %v", base, attempt, d, full/2, full)
+			}
+		}
+	}
+	if d := backoff(0, 3); d != 0 {
+		t.Errorf("backoff(0, 3) = %v, want 0", d)
+	}
+}
+
+func TestWithRetryBacksOff(t *testing.T) {
+	var delays []time.Duration
+	policy := RetryPolicy{MaxAttempts: 4, BaseBackoff: 100 * time.Millisecond}
+	policy.sleep = func(ctx context.Context, d time.Duration) error {
+		delays = append(delays, d)
+		return nil
+	}
+	attempts := 0
+	err := withRetry(context.Background(), policy, func() error {
+		attempts++
+		return &StatusError{StatusCode: http.StatusServiceUnavailable}
+	})
+	if err == nil {
+		t.Fatal("got nil error from an always failing call")
+	}
+	if attempts != 4 || len(delays) != 3 {
+		t.Fatalf("got %d attempts and %d sleeps, want 4 and 3", attempts, len(delays))
+	}
+	for i, d := range delays {
+		full := policy.BaseBackoff << i
+		if d < full/2 || d > full {
+			t.Errorf("delay before attempt %d: got %v, want between %v and %v", i+2, d, full/2, full)
+		}
+	}
+
+	// No retries for errors that would fail again, nor past the deadline.
+	delays, attempts = nil, 0
+	withRetry(context.Background(), policy, func() error {
+		attempts++
+		return &StatusError{StatusCode: http.StatusBadRequest}
+	})
+	if attempts != 1 {
+		t.Errorf("400: got %d attempts, want 1", attempts)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	attempts = 0
+	withRetry(ctx, policy, func() error {
+		attempts++
+		return &StatusError{StatusCode: http.StatusBadGateway}
+	})
+	if attempts != 1 || len(delays) != 0 {
+		t.Errorf("deadline shorter than the backoff: got %d attempts and %d sleeps, want 1 and 0", attempts, len(delays))
+	}
+}
+
+func TestLimiterPacesUpstreamCalls(t *testing.T) {
+	const interval = 50 * time.Millisecond
+	var (
+		mu    sync.Mutex
+		times []time.Time
+	)
+	board := serveBoard(5 * time.Minute)
+	stub := newStubRMV(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		times = append(times, time.Now())
+		mu.Unlock()
+		board(w, r)
+	})
+	client := stub.client()
+	client.Limiter = rate.NewLimiter(rate.Every(interval), 1)
+
+	for range 5 {
+		if _, err := client.Board(context.Background(), BoardDepartures, "3000001", 60, "de"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(times) != 5 {
+		t.Fatalf("upstream got %d calls, want 5", len(times))
+	}
+	for i := 1; i < len(times); i++ {
+		// Allow for timer granularity; the limiter itself is exact.
+		if gap := times[i].Sub(times[i-1]); gap < interval-5*time.Millisecond {
+			t.Errorf("calls %d and %d were %v apart, want at least %v", i, i+1, gap, interval)
+		}
+	}
+}
@@ -0,0 +1,413 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.43.0"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/sync/semaphore"
+	"golang.org/x/time/rate"
+)
+
+// HTTPDoer is the part of *http.Client the RMV client depends on, so tests
+// can substitute a stub and deployments can tune the transport.
+type HTTPDoer interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// defaultHTTPClient is used when an RMVClient has no HTTPClient set. It has
+// no timeout of its own; requests are bounded by RMVClient.Timeout.
+var defaultHTTPClient = &http.Client{}
+
+// TransportConfig tunes connection reuse towards RMV.
+type TransportConfig struct {
+	MaxIdleConns        int
+	MaxIdleConnsPerHost int
+	IdleConnTimeout     time.Duration
+}
+
+// newUpstreamTransport returns a transport for the RMV client based on
+// http.DefaultTransport. All traffic goes to a single host, so the main
+// change is raising MaxIdleConnsPerHost from Go's default of 2: with only two
+// idle connections kept, a burst of cache misses opens fresh TLS connections
+// and pays the handshake on every extra request. The default of 16 covers a
+// burst of misses across several stops, and the 90s idle timeout matches
+// Go's own default. HTTP/2 is attempted so concurrent requests can share one
+// connection where RMV supports it.
+func newUpstreamTransport(tc TransportConfig) *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.ForceAttemptHTTP2 = true
+	t.MaxIdleConns = tc.MaxIdleConns
+	t.MaxIdleConnsPerHost = tc.MaxIdleConnsPerHost
+	t.IdleConnTimeout = tc.IdleConnTimeout
+	return t
+}
+
+// RMVClient talks to the RMV HAFAS API.
+type RMVClient struct {
+	// HTTPClient performs the requests; defaultHTTPClient when nil.
+	HTTPClient HTTPDoer
+	APIKey     string
+	BaseURL    string
+	// UserAgent identifies this service in RMV's logs.
+	UserAgent string
+	Retry     RetryPolicy
+	// Timeout bounds each upstream attempt; zero leaves it to the caller's context.
+	Timeout time.Duration
+	// Limiter, if set, paces outbound requests to stay within RMV's quota.
+	// Each attempt waits for a token as described at waitLimiter.
+	Limiter *rate.Limiter
+	// Concurrency, if set, caps the number of requests in flight to RMV.
+	// Attempts wait for a slot for as long as their context allows.
+	Concurrency *semaphore.Weighted
+	// MaxResponseBytes caps the size of a response body; zero means no limit.
+	MaxResponseBytes int64
+	// Status, if set, records the outcome of every call.
+	Status *UpstreamStatus
+	// Breaker, if set, stops calls to RMV while it keeps failing.
+	Breaker *CircuitBreaker
+	// Maintenance, while set, fails every call with ErrMaintenance without
+	// contacting RMV, e.g. during a known RMV outage.
+	Maintenance atomic.Bool
+}
+
+// ResponseTooLargeError is returned when a response body exceeds
+// RMVClient.MaxResponseBytes.
+type ResponseTooLargeError struct {
+	Limit int64
+}
+
+func (e *ResponseTooLargeError) Error() string {
+	return fmt.Sprintf("API response exceeds the limit of %d bytes", e.Limit)
+}
+
+// ErrMaintenance is returned for calls made while RMVClient.Maintenance is set.
+var ErrMaintenance = errors.New("maintenance mode, upstream calls are disabled")
+
+// ErrRateLimited is returned when the client's own Limiter would hold a
+// request back past its deadline, or for longer than limiterMaxWait when the
+// caller has stale data to serve instead.
+var ErrRateLimited = errors.New("upstream rate limit reached")
+
+// limiterMaxWait is the longest a request marked withStaleFallback waits for
+// the Limiter. Beyond that it fails with ErrRateLimited, so the caller serves
+// its cached data instead.
+const limiterMaxWait = time.Second
+
+// ErrUpstreamBusy is returned when no Concurrency slot became free before
+// the caller's context was done.
+var ErrUpstreamBusy = errors.New("no free upstream request slot")
+
+type staleFallbackKey struct{}
+
+// withStaleFallback marks ctx as belonging to a caller that can serve stale
+// data if the call fails, so the Limiter does not keep it waiting.
+func withStaleFallback(ctx context.Context) context.Context {
+	return context.WithValue(ctx, staleFallbackKey{}, true)
+}
+
+func hasStaleFallback(ctx context.Context) bool {
+	fallback, _ := ctx.Value(staleFallbackKey{}).(bool)
+	return fallback
+}
+
+// rateLimited reports whether err means that a request was held back by our
+// rate limiter or refused by RMV's quota, with a 429 or an API_QUOTA error.
+func rateLimited(err error) bool {
+	var statusErr *StatusError
+	var apiErr *APIError
+	return errors.Is(err, ErrRateLimited) ||
+		(errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusTooManyRequests) ||
+		(errors.As(err, &apiErr) && strings.HasPrefix(apiErr.Code, "API_QUOTA"))
+}
+
+// DecodeError is returned when a response body is not the JSON expected, as
+// happens when RMV sends a truncated or HTML body during an outage.
+type DecodeError struct {
+	Service string
+	// RequestID is RMV's identifier of the call, if it sent one.
+	RequestID string
+	Err       error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("decoding %s response: %v", e.Service, e.Err)
+}
+
+func (e *DecodeError) Unwrap() error { return e.Err }
+
+// decodeSampleBytes is how much of an undecodable body is logged.
+const decodeSampleBytes = 512
+
+// Board fetches the departureBoard or arrivalBoard of a stop, with names
+// and messages in lang.
+func (c *RMVClient) Board(ctx context.Context, boardType BoardType, stopID string, duration int, lang string) (*DepartureBoard, error) {
+	params := url.Values{}
+	params.Set("id", stopID)
+	params.Set("duration", strconv.Itoa(duration))
+	params.Set("lang", lang)
+
+	var board DepartureBoard
+	if err := c.call(ctx, string(boardType), params, &board); err != nil {
+		return nil, err
+	}
+	if removed := board.dedupe(); removed > 0 {
+		slog.WarnContext(ctx, "removed duplicate board entries", "board", boardType, "stopId", stopID, "removed", removed)
+	}
+	return &board, nil
+}
+
+// call performs a GET on the given service with retries and decodes the JSON
+// response into dst.
+func (c *RMVClient) call(ctx context.Context, service string, params url.Values, dst any) error {
+	if c.Maintenance.Load() {
+		return ErrMaintenance
+	}
+	var ticket breakerTicket
+	if c.Breaker != nil {
+		var err error
+		if ticket, err = c.Breaker.Allow(); err != nil {
+			return err
+		}
+	}
+	err := withRetry(ctx, c.Retry, func() error {
+		return c.get(ctx, service, params, dst)
+	})
+	if c.Breaker != nil {
+		c.Breaker.Record(ticket, err)
+	}
+	// Neither an invalid request nor our own rate limit or concurrency cap
+	// says anything about RMV's health.
+	var apiErr *APIError
+	if c.Status != nil && !(errors.As(err, &apiErr) && apiErr.InvalidRequest()) && !errors.Is(err, ErrRateLimited) && !errors.Is(err, ErrUpstreamBusy) {
+		if err != nil {
+			c.Status.RecordFailure(err)
+		} else {
+			c.Status.RecordSuccess()
+		}
+	}
+	return err
+}
+
+// waitLimiter waits for a Limiter token up to the context deadline, or up to
+// limiterMaxWait if the caller has a stale fallback. It returns
+// ErrRateLimited at once when the wait would be longer.
+func (c *RMVClient) waitLimiter(ctx context.Context) error {
+	maxWait := time.Duration(math.MaxInt64)
+	if hasStaleFallback(ctx) {
+		maxWait = limiterMaxWait
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		maxWait = min(maxWait, time.Until(deadline))
+	}
+	r := c.Limiter.Reserve()
+	delay := r.Delay()
+	if !r.OK() || delay > maxWait {
+		r.Cancel()
+		return ErrRateLimited
+	}
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		r.Cancel()
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// get performs a single request against the given service.
+func (c *RMVClient) get(ctx context.Context, service string, params url.Values, dst any) error {
+	// Waiting for the limiter and a slot is bounded by the caller's deadline,
+	// not the attempt timeout, so a queue of cold misses can wait its turn.
+	if c.Limiter != nil {
+		if err := c.waitLimiter(ctx); err != nil {
+			return err
+		}
+	}
+	if c.Concurrency != nil {
+		if err := c.Concurrency.Acquire(ctx, 1); err != nil {
+			return fmt.Errorf("%w: %w", ErrUpstreamBusy, err)
+		}
+		defer c.Concurrency.Release(1)
+	}
+	if c.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
+
+	ctx, span := tracer.Start(ctx, "rmv "+service, trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("rmv.service", service), attribute.String("rmv.id", params.Get("id"))))
+	defer span.End()
+
+	endpoint, err := url.JoinPath(c.BaseURL, service)
+	if err != nil {
+		return err
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return err
+	}
+
+	q := u.Query()
+	for key, values := range params {
+		q[key] = values
+	}
+	q.Set("accessId", c.APIKey)
+	q.Set("format", "json")
+	u.RawQuery = q.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return err
+	}
+	if c.UserAgent != "" {
+		req.Header.Set("User-Agent", c.UserAgent)
+	}
+
+	client := c.HTTPClient
+	if client == nil {
+		client = defaultHTTPClient
+	}
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		observeUpstream(start, "network")
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "request failed")
+		return err
+	}
+	span.SetAttributes(semconv.HTTPResponseStatusCode(resp.StatusCode))
+	defer func(Body io.ReadCloser) {
+		err := Body.Close()
+		if err != nil {
+			slog.ErrorContext(ctx, "failed to close response body", "error", err)
+		}
+	}(resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		observeUpstream(start, strconv.Itoa(resp.StatusCode))
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+		statusErr := &StatusError{StatusCode: resp.StatusCode, RequestID: upstreamRequestIDFrom(resp.Header, body)}
+		span.SetStatus(codes.Error, statusErr.Error())
+		if statusErr.AuthFailed() {
+			slog.ErrorContext(ctx, "RMV rejected the API key, check RMV_API_KEY", "service", service, "status", resp.StatusCode, "upstreamRequestId", statusErr.RequestID)
+		}
+		return statusErr
+	}
+	observeUpstream(start, "")
+
+	body, err := c.readBody(resp.Body)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+	var apiErr APIError
+	if err := json.Unmarshal(body, &apiErr); err == nil && apiErr.Code != "" {
+		apiErr.RequestID = cmp.Or(apiErr.RequestID, resp.Header.Get("X-Request-Id"))
+		upstreamErrorsTotal.WithLabelValues(apiErr.Code).Inc()
+		span.SetAttributes(attribute.String("rmv.error_code", apiErr.Code))
+		span.SetStatus(codes.Error, apiErr.Error())
+		return &apiErr
+	}
+	if err := json.Unmarshal(body, dst); err != nil {
+		decodeErr := &DecodeError{Service: service, RequestID: resp.Header.Get("X-Request-Id"), Err: err}
+		upstreamErrorsTotal.WithLabelValues("decode").Inc()
+		span.SetStatus(codes.Error, decodeErr.Error())
+		sample := body[:min(len(body), decodeSampleBytes)]
+		slog.WarnContext(ctx, "undecodable upstream response", "service", service, "error", err,
+			"bytes", len(body), "sample", string(sample))
+		return decodeErr
+	}
+	return nil
+}
+
+// readBody reads a response body up to MaxResponseBytes.
+func (c *RMVClient) readBody(body io.Reader) ([]byte, error) {
+	if c.MaxResponseBytes <= 0 {
+		return io.ReadAll(body)
+	}
+	data, err := io.ReadAll(io.LimitReader(body, c.MaxResponseBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > c.MaxResponseBytes {
+		return nil, &ResponseTooLargeError{Limit: c.MaxResponseBytes}
+	}
+	return data, nil
+}
+
+// APIError is an error object the RMV API returned in place of the
+// requested data, usually with status 200.
+type APIError struct {
+	Code      string `json:"errorCode"`
+	Text      string `json:"errorText"`
+	RequestID string `json:"requestId"`
+}
+
+func (e *APIError) Error() string {
+	if e.Text == "" {
+		return "API error " + e.Code
+	}
+	return fmt.Sprintf("API error %s: %s", e.Code, e.Text)
+}
+
+// upstreamRequestIDFrom returns RMV's identifier of a call, taken from the
+// requestId field of a JSON body or else the X-Request-Id response header.
+func upstreamRequestIDFrom(header http.Header, body []byte) string {
+	var payload struct {
+		RequestID string `json:"requestId"`
+	}
+	_ = json.Unmarshal(body, &payload)
+	return cmp.Or(payload.RequestID, header.Get("X-Request-Id"))
+}
+
+// upstreamRequestID returns the RMV request ID carried by a failed call's
+// error, or "" if RMV did not send one.
+func upstreamRequestID(err error) string {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.RequestID
+	}
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.RequestID
+	}
+	var decodeErr *DecodeError
+	if errors.As(err, &decodeErr) {
+		return decodeErr.RequestID
+	}
+	return ""
+}
+
+// InvalidRequest reports whether RMV rejected the request itself, e.g. an
+// unknown stop or an invalid parameter, rather than failing to answer it.
+func (e *APIError) InvalidRequest() bool {
+	for _, prefix := range []string{"SVC_LOC", "SVC_PARAM", "SVC_DATATIME"} {
+		if strings.HasPrefix(e.Code, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/sync/semaphore"
+)
+
+// The fixtures under testdata/rmv-errors are error payloads as RMV sends
+// them, with status 200 in place of a departure board.
+func TestUpstreamErrorPayloads(t *testing.T) {
+	for _, tc := range []struct {
+		fixture    string
+		wantStatus int
+		wantCode   string
+		wantText   string
+	}{
+		{"svc_loc.json", http.StatusBadRequest, "upstream_rejected", "location missing or invalid"},
+		{"svc_param.json", http.StatusBadRequest, "upstream_rejected", "parameter invalid"},
+		{"svc_datatime.json", http.StatusBadRequest, "upstream_rejected", "date not in timetable period"},
+		{"api_quota.json", http.StatusServiceUnavailable, "upstream_rate_limited", ""},
+		{"svc_fail.json", http.StatusBadGateway, "upstream_error", "Failed to fetch departures: search failed"},
+	} {
+		t.Run(tc.fixture, func(t *testing.T) {
+			payload, err := os.ReadFile(filepath.Join("testdata", "rmv-errors", tc.fixture))
+			if err != nil {
+				t.Fatal(err)
+			}
+			stub := newStubRMV(t, serveBody(http.StatusOK, payload))
+			server := newTestServer(t, testConfig(t, stub.URL), stub.client())
+
+			var body errorBody
+			resp := getJSON(t, server.URL+"/next-departures?stopId=3000001", &body)
+			if resp.StatusCode != tc.wantStatus {
+				t.Errorf("got status %d, want %d", resp.StatusCode, tc.wantStatus)
+			}
+			if body.Error.Code != tc.wantCode {
+				t.Errorf("got code %q, want %q", body.Error.Code, tc.wantCode)
+			}
+			if tc.wantText != "" && body.Error.Message != tc.wantText {
+				t.Errorf("got message %q, want %q", body.Error.Message, tc.wantText)
+			}
+			if resp.Header.Get("X-Upstream-Request-ID") == "" {
+				t.Error("X-Upstream-Request-ID not set from the payload's requestId")
+			}
+		})
+	}
+}
+
+func TestOversizedResponseIsRejected(t *testing.T) {
+	const limit, chunkSize, chunks = 64 << 10, 4 << 10, 4096
+	var written atomic.Int64
+	stub := newStubRMV(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		chunk := bytes.Repeat([]byte(" "), chunkSize)
+		// Stream far more than the limit, stopping once the client hangs up.
+		for range chunks {
+			if _, err := w.Write(chunk); err != nil {
+				return
+			}
+			written.Add(int64(len(chunk)))
+		}
+	})
+	client := stub.client()
+	client.MaxResponseBytes = limit
+
+	_, err := client.Board(context.Background(), BoardDepartures, "3000001", 60, "de")
+	var tooLarge *ResponseTooLargeError
+	if !errors.As(err, &tooLarge) {
+		t.Fatalf("got error %v, want a ResponseTooLargeError", err)
+	}
+	if tooLarge.Limit != limit {
+		t.Errorf("got limit %d, want %d", tooLarge.Limit, limit)
+	}
+	if n := written.Load(); n >= chunkSize*chunks {
+		t.Errorf("the whole %d byte body was read, want reading to stop at the limit", n)
+	}
+}
+
+func TestMalformedUpstreamJSON(t *testing.T) {
+	for name, payload := range map[string]string{
+		"truncated":  `{"Departure":[{"name":"Tram 12","direction":"Hauptbahnhof","da`,
+		"html":       `<html><body>502 Bad Gateway</body></html>`,
+		"wrong type": `{"Departure":{"name":"Tram 12"}}`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			stub := newStubRMV(t, serveBody(http.StatusOK, []byte(payload)))
+			server := newTestServer(t, testConfig(t, stub.URL), stub.client())
+
+			var body errorBody
+			resp := getJSON(t, server.URL+"/next-departures?stopId=3000001", &body)
+			if resp.StatusCode != http.StatusBadGateway {
+				t.Errorf("got status %d, want 502", resp.StatusCode)
+			}
+			if body.Error.Code != "upstream_decode_error" {
+				t.Errorf("got code %q, want upstream_decode_error", body.Error.Code)
+			}
+		})
+	}
+}
+
+func TestConcurrencyCapsInFlightCalls(t *testing.T) {
+	const limit = 3
+	var tracker peakTracker
+	stub := newStubRMV(t, tracker.serve(20*time.Millisecond, serveBoard(5*time.Minute)))
+	client := stub.client()
+	client.Concurrency = semaphore.NewWeighted(limit)
+
+	var wg sync.WaitGroup
+	for i := range 20 {
+		wg.Go(func() {
+			if _, err := client.Board(context.Background(), BoardDepartures, strconv.Itoa(3000000+i), 60, "de"); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+	wg.Wait()
+	if n := stub.calls.Load(); n != 20 {
+		t.Errorf("upstream got %d calls, want 20", n)
+	}
+	if p := tracker.peak(); p != limit {
+		t.Errorf("got at most %d calls in flight, want %d", p, limit)
+	}
+}
+
+func TestConcurrencyWaitIsNotAnUpstreamFailure(t *testing.T) {
+	stub := newStubRMV(t, serveBoard(5*time.Minute))
+	client := stub.client()
+	client.Concurrency = semaphore.NewWeighted(1)
+	// Shorter than the wait for a slot, which must not count against it.
+	client.Timeout = 50 * time.Millisecond
+	client.Retry = RetryPolicy{MaxAttempts: 3}
+	client.Breaker = NewCircuitBreaker(1, time.Minute)
+	if err := client.Concurrency.Acquire(context.Background(), 1); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Concurrency.Release(1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := client.Board(ctx, BoardDepartures, "3000001", 60, "de")
+	if !errors.Is(err, ErrUpstreamBusy) {
+		t.Fatalf("got error %v, want ErrUpstreamBusy", err)
+	}
+	if elapsed := time.Since(start); elapsed < 250*time.Millisecond {
+		t.Errorf("gave up after %v, want a wait until the request deadline", elapsed)
+	}
+	if n := stub.calls.Load(); n != 0 {
+		t.Errorf("upstream got %d calls, want 0", n)
+	}
+	if state := client.Breaker.State(); state != breakerClosed {
+		t.Errorf("got breaker state %s, want closed", state)
+	}
+	if status, detail := fetchError(ctx, err, "departures"); status != http.StatusServiceUnavailable || detail.Code != "upstream_busy" {
+		t.Errorf("got %d %s, want 503 upstream_busy", status, detail.Code)
+	}
+}
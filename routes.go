@@ -0,0 +1,398 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// requireAdmin checks the request's bearer token against token and writes a
+// 401 when it does not match. It reports whether the request may proceed.
+func requireAdmin(w http.ResponseWriter, r *http.Request, token string) bool {
+	given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeJSONError(w, http.StatusUnauthorized, "unauthorized", "A valid admin token is required")
+		return false
+	}
+	return true
+}
+
+// newHandler builds the service's routes wrapped in its middleware. Open
+// event streams end when shutdown is closed; refresher may be nil.
+func newHandler(shutdown <-chan struct{}, config Config, cache Cache, client *RMVClient, status *UpstreamStatus, limiter *ipRateLimiter, refresher *Refresher) http.Handler {
+	mux := http.NewServeMux()
+
+	// Liveness: the process is up and serving requests
+	mux.HandleFunc("GET /health", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	})
+
+	// Readiness: based on configuration and the outcome of the last upstream call
+	mux.HandleFunc("GET /ready", func(w http.ResponseWriter, r *http.Request) {
+		report := status.Readiness(config.APIKey != "")
+		if client.Breaker != nil {
+			report.CircuitBreaker = client.Breaker.State()
+		}
+		report.Maintenance = client.Maintenance.Load()
+		code := http.StatusOK
+		if report.Status != "ok" {
+			code = http.StatusServiceUnavailable
+		}
+		writeJSON(w, code, report)
+	})
+
+	mux.Handle("GET /metrics", promhttp.Handler())
+
+	// OpenAPI description of this API, for client generators
+	mux.HandleFunc("GET /openapi.json", serveOpenAPI)
+
+	// Hit and miss counts of this instance's cache lookups
+	mux.HandleFunc("GET /cache/stats", func(w http.ResponseWriter, r *http.Request) {
+		stats := cache.Stats()
+		stats.Coalesced = coalescedFetches.Load()
+		writeJSON(w, http.StatusOK, stats)
+	})
+
+	// Build version of the running binary
+	mux.HandleFunc("GET /version", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, currentBuildInfo())
+	})
+
+	// Effective configuration with secrets redacted, for operators
+	mux.HandleFunc("GET /config", func(w http.ResponseWriter, r *http.Request) {
+		if config.AdminToken != "" && !requireAdmin(w, r, config.AdminToken) {
+			return
+		}
+		writeJSON(w, http.StatusOK, config.report())
+	})
+
+	// Drops cached data, all of it or that of one stop. Only available
+	// with an admin token configured.
+	if config.AdminToken != "" {
+		mux.HandleFunc("POST /cache/purge", func(w http.ResponseWriter, r *http.Request) {
+			if !requireAdmin(w, r, config.AdminToken) {
+				return
+			}
+			stopID := strings.TrimSpace(r.URL.Query().Get("stopId"))
+			prefixes := []string{""}
+			if stopID != "" {
+				prefixes = []string{string(BoardDepartures) + ":" + stopID + ":", string(BoardArrivals) + ":" + stopID + ":"}
+			}
+			removed := 0
+			for _, prefix := range prefixes {
+				n, err := cache.DeletePrefix(r.Context(), prefix)
+				removed += n
+				if err != nil {
+					slog.ErrorContext(r.Context(), "cache purge failed", "stopId", stopID, "removed", removed, "error", err)
+					writeJSONError(w, http.StatusInternalServerError, "purge_failed", "Failed to purge the cache")
+					return
+				}
+			}
+			slog.InfoContext(r.Context(), "cache purged", "stopId", stopID, "removed", removed)
+			writeJSON(w, http.StatusOK, map[string]any{"removed": removed, "stopId": stopID})
+		})
+	}
+
+	// Switches maintenance mode on or off at runtime. Only available with
+	// an admin token configured.
+	if config.AdminToken != "" {
+		mux.HandleFunc("POST /maintenance", func(w http.ResponseWriter, r *http.Request) {
+			if !requireAdmin(w, r, config.AdminToken) {
+				return
+			}
+			enabled, err := strconv.ParseBool(r.URL.Query().Get("enabled"))
+			if err != nil {
+				writeRequestError(w, badParameter("enabled must be true or false"))
+				return
+			}
+			client.Maintenance.Store(enabled)
+			slog.WarnContext(r.Context(), "maintenance mode changed", "enabled", enabled)
+			writeJSON(w, http.StatusOK, map[string]bool{"maintenance": enabled})
+		})
+	}
+
+	// Lists the configured named stops
+	mux.HandleFunc("GET /stops", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, config.Stops)
+	})
+
+	// Handler for next departures and arrivals
+	boardHandler := func(boardType BoardType) http.Handler {
+		return rateLimitMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			q, reqErr := parseBoardQuery(r, config)
+			if reqErr != nil {
+				writeRequestError(w, reqErr)
+				return
+			}
+
+			// An explicit ?format= wins over the Accept header.
+			format := q.Format
+			if r.URL.Query().Get("format") == "" {
+				var ok bool
+				if format, ok = negotiateFormat(r.Header.Get("Accept")); !ok {
+					writeJSONError(w, http.StatusNotAcceptable, "not_acceptable", "Accept must allow one of application/json, text/csv, application/xml")
+					return
+				}
+			}
+
+			if refresher != nil {
+				refresher.Touch(refreshTarget{Board: boardType, StopID: q.StopID, Duration: q.Duration, Lang: q.Lang})
+			}
+
+			result, err := fetchBoardMaxAge(r.Context(), cache, client, config, q.StopID, q.Duration, q.Lang, boardType, q.maxAge(config))
+			if err != nil {
+				writeFetchError(w, r, err, boardType.resource(), "stopId", q.StopID)
+				return
+			}
+			if reqErr := q.Cursor.checkSnapshot(result.StoredAt); reqErr != nil {
+				writeRequestError(w, reqErr)
+				return
+			}
+
+			board := q.apply(result.Board, config.Location, time.Now())
+			if board.NextOffset > 0 {
+				board.Meta.NextCursor = boardCursor{StoredAt: result.StoredAt.UnixNano(), Offset: board.NextOffset}.String()
+				w.Header().Set("X-Next-Cursor", board.Meta.NextCursor)
+			}
+
+			w.Header().Add("Vary", "Accept")
+			w.Header().Add("Vary", "Accept-Language")
+
+			var body []byte
+			contentType := "application/json"
+			switch format {
+			case formatCSV:
+				body, err = boardCSV(board, config.Location)
+				contentType = "text/csv; charset=utf-8"
+			case formatXML:
+				body, err = boardXML(boardType, q.StopID, board, config.Location)
+				contentType = "application/xml; charset=utf-8"
+			default:
+				body, err = json.Marshal(q.View.Transform(boardType, q.StopID, board))
+				body = append(body, '\n')
+			}
+			if err != nil {
+				slog.ErrorContext(r.Context(), "failed to encode "+boardType.resource(), "error", err)
+				writeJSONError(w, http.StatusInternalServerError, "internal_error", "Failed to encode "+boardType.resource())
+				return
+			}
+
+			w.Header().Set("Content-Type", contentType)
+			setUpstreamRequestID(w, result.Board.RequestID)
+			w.Header().Set("Cache-Control", "public, max-age="+strconv.Itoa(max(0, int(time.Until(result.ExpiresAt).Seconds()))))
+			// A header rather than a body field, so the ETag only changes
+			// with the data.
+			w.Header().Set("Refresh", strconv.Itoa(result.PollAfterSeconds()))
+			switch {
+			case result.RateLimited:
+				w.Header().Set("X-Cache", "STALE-RATELIMITED")
+			case result.Stale:
+				w.Header().Set("X-Cache", "STALE")
+			case result.Hit:
+				w.Header().Set("X-Cache", "HIT")
+			default:
+				w.Header().Set("X-Cache", "MISS")
+			}
+			w.Header().Set("X-Cache-Age", strconv.Itoa(int(result.Age().Seconds())))
+			writeConditional(w, r, body, result.StoredAt)
+		}), limiter)
+	}
+	mux.Handle("GET /next-departures", boardHandler(BoardDepartures))
+	mux.Handle("GET /next-arrivals", boardHandler(BoardArrivals))
+
+	// Live departure updates as Server-Sent Events
+	mux.Handle("GET /next-departures/stream", rateLimitMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q, reqErr := parseBoardQuery(r, config)
+		if reqErr != nil {
+			writeRequestError(w, reqErr)
+			return
+		}
+		if q.Format != formatJSON {
+			writeRequestError(w, badParameter("streams only support format=json"))
+			return
+		}
+		if q.Cursor != nil {
+			writeRequestError(w, badParameter("streams do not support cursor"))
+			return
+		}
+		if refresher != nil {
+			refresher.Touch(refreshTarget{Board: BoardDepartures, StopID: q.StopID, Duration: q.Duration, Lang: q.Lang})
+		}
+
+		key := boardCacheKey(BoardDepartures, q.StopID, q.Duration, q.Lang)
+		streamBoard(w, r, shutdown, key, config.StreamInterval, func(ctx context.Context) (boardResult, error) {
+			return fetchBoardMaxAge(ctx, cache, client, config, q.StopID, q.Duration, q.Lang, BoardDepartures, q.maxAge(config))
+		}, func(board *DepartureBoard) any {
+			return q.View.Transform(BoardDepartures, q.StopID, q.apply(board, config.Location, time.Now()))
+		})
+	}), limiter))
+
+	// Only the soonest upcoming departure, for one-line widgets
+	mux.Handle("GET /next-departure", rateLimitMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q, reqErr := parseBoardQuery(r, config)
+		if reqErr == nil && q.Format != formatJSON {
+			reqErr = badParameter("next-departure only supports format=json")
+		}
+		if reqErr == nil && q.Cursor != nil {
+			reqErr = badParameter("next-departure does not support cursor")
+		}
+		if reqErr != nil {
+			writeRequestError(w, reqErr)
+			return
+		}
+		if refresher != nil {
+			refresher.Touch(refreshTarget{Board: BoardDepartures, StopID: q.StopID, Duration: q.Duration, Lang: q.Lang})
+		}
+
+		result, err := fetchBoardMaxAge(r.Context(), cache, client, config, q.StopID, q.Duration, q.Lang, BoardDepartures, q.maxAge(config))
+		if err != nil {
+			writeFetchError(w, r, err, "departures", "stopId", q.StopID)
+			return
+		}
+		q.Limit, q.IncludeDeparted = 1, false
+		board := q.apply(result.Board, config.Location, time.Now())
+
+		setUpstreamRequestID(w, result.Board.RequestID)
+		w.Header().Set("Cache-Control", "public, max-age="+strconv.Itoa(max(0, int(time.Until(result.ExpiresAt).Seconds()))))
+		w.Header().Set("Refresh", strconv.Itoa(result.PollAfterSeconds()))
+		w.Header().Add("Vary", "Accept-Language")
+		if len(board.Departures) == 0 {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		writeJSON(w, http.StatusOK, board.Departures[0])
+	}), limiter))
+
+	// Products serving a stop, from its cached departure board, for filter UIs
+	mux.Handle("GET /next-departures/products", rateLimitMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q, reqErr := parseBoardQuery(r, config)
+		if reqErr != nil {
+			writeRequestError(w, reqErr)
+			return
+		}
+
+		result, err := fetchBoard(r.Context(), cache, client, config, q.StopID, q.Duration, q.Lang, BoardDepartures)
+		if err != nil {
+			writeFetchError(w, r, err, "products", "stopId", q.StopID)
+			return
+		}
+		setUpstreamRequestID(w, result.Board.RequestID)
+		writeJSON(w, http.StatusOK, map[string]any{"stopId": q.StopID, "products": boardProducts(result.Board)})
+	}), limiter))
+
+	// Departures of several stops in one request. Query parameters other
+	// than stop/stopId apply to every stop.
+	mux.Handle("POST /departures/batch", rateLimitMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q, reqErr := parseBoardQuery(r, config)
+		if reqErr == nil && q.Format != formatJSON {
+			reqErr = badParameter("batch requests only support format=json")
+		}
+		if reqErr == nil && q.Cursor != nil {
+			reqErr = badParameter("batch requests do not support cursor")
+		}
+		if reqErr != nil {
+			writeRequestError(w, reqErr)
+			return
+		}
+		stopIDs, reqErr := parseBatchRequest(w, r, config.BatchMaxStops, config.BatchMaxBodyBytes)
+		if reqErr != nil {
+			writeRequestError(w, reqErr)
+			return
+		}
+
+		if refresher != nil {
+			for _, stopID := range stopIDs {
+				if config.stopAllowed(stopID) {
+					refresher.Touch(refreshTarget{Board: BoardDepartures, StopID: stopID, Duration: q.Duration, Lang: q.Lang})
+				}
+			}
+		}
+		writeJSON(w, http.StatusOK, fetchBatch(r.Context(), cache, client, config, q, stopIDs))
+	}), limiter))
+
+	// Stops matching a name, for autocompletion
+	mux.Handle("GET /stops/search", rateLimitMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := strings.TrimSpace(r.URL.Query().Get("q"))
+		if utf8.RuneCountInString(query) < 2 {
+			writeRequestError(w, badParameter("q must be at least 2 characters"))
+			return
+		}
+
+		key := "stops:search:" + strings.ToLower(query)
+		stops, err := fetchStops(r.Context(), cache, config, key, func(ctx context.Context) ([]stopResult, error) {
+			return client.SearchStops(ctx, query)
+		})
+		if err != nil {
+			writeFetchError(w, r, err, "stops", "q", query)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"stops": stops})
+	}), limiter))
+
+	// Stops around a coordinate, nearest first
+	mux.Handle("GET /stops/nearby", rateLimitMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q, reqErr := parseNearbyQuery(r)
+		if reqErr != nil {
+			writeRequestError(w, reqErr)
+			return
+		}
+
+		key := fmt.Sprintf("stops:nearby:%.3f:%.3f:%d", q.Lat, q.Lon, q.Radius)
+		stops, err := fetchStops(r.Context(), cache, config, key, func(ctx context.Context) ([]stopResult, error) {
+			return client.NearbyStops(ctx, q.Lat, q.Lon, q.Radius)
+		})
+		if err != nil {
+			writeFetchError(w, r, err, "stops", "lat", q.Lat, "lon", q.Lon)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"stops": stops})
+	}), limiter))
+
+	// Full stop-by-stop route of a trip, by the JourneyDetailRef of a departure
+	mux.Handle("GET /journey", rateLimitMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ref := strings.TrimSpace(r.URL.Query().Get("ref"))
+		if ref == "" {
+			writeJSONError(w, http.StatusBadRequest, "invalid_parameter", "ref is required")
+			return
+		}
+
+		journey, err := fetchJourney(r.Context(), cache, client, config, ref)
+		if err != nil {
+			writeFetchError(w, r, err, "journey", "ref", ref)
+			return
+		}
+		setUpstreamRequestID(w, journey.RequestID)
+		writeJSON(w, http.StatusOK, journey)
+	}), limiter))
+
+	// Connections from one stop to another
+	mux.Handle("GET /trip", rateLimitMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q, reqErr := parseTripQuery(r, config.Location)
+		if reqErr != nil {
+			writeRequestError(w, reqErr)
+			return
+		}
+
+		trips, err := fetchTrips(r.Context(), cache, client, config, q)
+		if err != nil {
+			writeFetchError(w, r, err, "trips", "from", q.From, "to", q.To)
+			return
+		}
+		setUpstreamRequestID(w, trips.RequestID)
+		writeJSON(w, http.StatusOK, trips)
+	}), limiter))
+
+	// metricsMiddleware and tracingMiddleware must wrap the mux directly so
+	// they see r.Pattern.
+	return clientIPMiddleware(accessLogMiddleware(corsMiddleware(gzipMiddleware(recoverMiddleware(timeoutMiddleware(metricsMiddleware(tracingMiddleware(mux)), config.RequestTimeout)), config.GzipMinSize), config.AllowedOrigins, config.CORS)), config.TrustedProxies)
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestNextDeparturesEndToEnd(t *testing.T) {
+	stub := newStubRMV(t, serveBoard(3*time.Minute, 10*time.Minute))
+	config := testConfig(t, stub.URL)
+	server := newTestServer(t, config, stub.client())
+
+	var body struct {
+		StopID     string `json:"stopId"`
+		Departures []struct {
+			Line         string `json:"line"`
+			Direction    string `json:"direction"`
+			MinutesUntil *int   `json:"minutesUntil"`
+		} `json:"departures"`
+	}
+	resp := getJSON(t, server.URL+"/next-departures?stopId=3000001", &body)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("got status %d, want 200", resp.StatusCode)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "application/json" {
+		t.Errorf("got Content-Type %q, want application/json", ct)
+	}
+	if got := resp.Header.Get("X-Cache"); got != "MISS" {
+		t.Errorf("first request: got X-Cache %q, want MISS", got)
+	}
+	if refresh, err := strconv.Atoi(resp.Header.Get("Refresh")); err != nil || refresh < 295 || refresh > 300 {
+		t.Errorf("got Refresh %q, want the seconds until the entry expires after the default 5m", resp.Header.Get("Refresh"))
+	}
+	if body.StopID != "3000001" {
+		t.Errorf("got stopId %q, want 3000001", body.StopID)
+	}
+	if len(body.Departures) != 2 {
+		t.Fatalf("got %d departures, want 2", len(body.Departures))
+	}
+	for _, d := range body.Departures {
+		if d.Line != "12" || d.Direction != "Hauptbahnhof" || d.MinutesUntil == nil {
+			t.Errorf("unexpected departure %+v", d)
+		}
+	}
+
+	resp = getJSON(t, server.URL+"/next-departures?stopId=3000001", nil)
+	if got := resp.Header.Get("X-Cache"); got != "HIT" {
+		t.Errorf("second request: got X-Cache %q, want HIT", got)
+	}
+	if n := stub.calls.Load(); n != 1 {
+		t.Errorf("upstream got %d calls, want 1", n)
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// NamedStop is a stop configured under a friendly name via STOPS.
+type NamedStop struct {
+	Name string `json:"name"`
+	ID   string `json:"id"`
+}
+
+// parseStops parses a STOPS value of the form "home:3000001,work:3000510".
+func parseStops(raw string) ([]NamedStop, error) {
+	var stops []NamedStop
+	seen := make(map[string]bool)
+	for _, item := range splitList(raw) {
+		name, id, ok := strings.Cut(item, ":")
+		name, id = strings.TrimSpace(name), strings.TrimSpace(id)
+		if !ok || name == "" || id == "" {
+			return nil, fmt.Errorf("invalid STOPS entry %q, expected name:id", item)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("duplicate STOPS name %q", name)
+		}
+		seen[name] = true
+		stops = append(stops, NamedStop{Name: name, ID: id})
+	}
+	return stops, nil
+}
+
+// lookupStop resolves a configured stop name to its ID.
+func (c Config) lookupStop(name string) (string, bool) {
+	for _, s := range c.Stops {
+		if s.Name == name {
+			return s.ID, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Broadcaster fans out freshly fetched boards to subscribers, keyed by cache key.
+type Broadcaster struct {
+	mu   sync.Mutex
+	subs map[string]map[chan boardResult]struct{}
+}
+
+func NewBroadcaster() *Broadcaster {
+	return &Broadcaster{subs: make(map[string]map[chan boardResult]struct{})}
+}
+
+// boardUpdates is notified whenever a board is fetched from upstream.
+var boardUpdates = NewBroadcaster()
+
+// Subscribe registers for updates of key. The returned function must be
+// called to unsubscribe.
+func (b *Broadcaster) Subscribe(key string) (<-chan boardResult, func()) {
+	ch := make(chan boardResult, 1)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.subs[key] == nil {
+		b.subs[key] = make(map[chan boardResult]struct{})
+	}
+	b.subs[key][ch] = struct{}{}
+
+	return ch, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		delete(b.subs[key], ch)
+		if len(b.subs[key]) == 0 {
+			delete(b.subs, key)
+		}
+	}
+}
+
+// Publish delivers result to all subscribers of key. Slow subscribers that
+// still have an undelivered update pending are skipped rather than blocking.
+func (b *Broadcaster) Publish(key string, result boardResult) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subs[key] {
+		select {
+		case ch <- result:
+		default:
+		}
+	}
+}
+
+// streamBoard serves a board as Server-Sent Events. A new event is sent
+// whenever the board is refreshed; between refreshes the board is re-checked
+// every interval, which also keeps the connection alive. The stream ends when
+// the client disconnects or shutdown is closed, so open streams do not hold
+// up a graceful shutdown.
+func streamBoard(w http.ResponseWriter, r *http.Request, shutdown <-chan struct{}, key string, interval time.Duration, fetch func(ctx context.Context) (boardResult, error), transform func(*DepartureBoard) any) {
+	ctx := r.Context()
+	rc := http.NewResponseController(w)
+
+	updates, unsubscribe := boardUpdates.Subscribe(key)
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	var lastStored time.Time
+	send := func(result boardResult) error {
+		if !result.StoredAt.After(lastStored) {
+			_, err := fmt.Fprint(w, ": keep-alive\n\n")
+			if err == nil {
+				err = rc.Flush()
+			}
+			return err
+		}
+		data, err := json.Marshal(transform(result.Board))
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "data: %s\n\n", data); err != nil {
+			return err
+		}
+		lastStored = result.StoredAt
+		return rc.Flush()
+	}
+	poll := func() error {
+		result, err := fetch(ctx)
+		if err != nil {
+			if ctx.Err() == nil {
+				slog.WarnContext(ctx, "stream refresh failed", "error", err)
+			}
+			// Keep the stream open and try again on the next tick.
+			return send(boardResult{})
+		}
+		return send(result)
+	}
+
+	if err := poll(); err != nil {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		var err error
+		select {
+		case <-ctx.Done():
+			slog.DebugContext(ctx, "stream client disconnected")
+			return
+		case <-shutdown:
+			slog.DebugContext(ctx, "closing stream for shutdown")
+			return
+		case result := <-updates:
+			err = send(result)
+		case <-ticker.C:
+			err = poll()
+		}
+		if err != nil {
+			slog.DebugContext(ctx, "stream write failed", "error", err)
+			return
+		}
+	}
+}
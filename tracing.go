@@ -0,0 +1,86 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"net/http"
+	"os"
+	"strconv"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.43.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer creates this service's spans. It delegates to the provider
+// installed by setupTracing and is a no-op while none is.
+var tracer = otel.Tracer("rmv-backend-go")
+
+// setupTracing exports spans over OTLP/HTTP when an exporter endpoint is
+// configured through the standard OTEL_EXPORTER_OTLP_ENDPOINT or
+// OTEL_EXPORTER_OTLP_TRACES_ENDPOINT variables; otherwise tracing stays a
+// no-op. The returned function flushes and stops the exporter.
+func setupTracing(ctx context.Context) (shutdown func(context.Context) error, err error) {
+	if os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") == "" && os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return nil, err
+	}
+	res, err := resource.Merge(
+		resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(cmp.Or(os.Getenv("OTEL_SERVICE_NAME"), "rmv-backend-go"))),
+		resource.Environment(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	provider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter), sdktrace.WithResource(res))
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	return provider.Shutdown, nil
+}
+
+// tracingMiddleware starts a server span per request, continuing a trace
+// propagated by the caller. It must wrap the mux directly: the span is named
+// after the matched route, and the route is copied back onto r so
+// metricsMiddleware still sees it.
+func tracingMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracer.Start(ctx, r.Method, trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(semconv.HTTPRequestMethodKey.String(r.Method), semconv.URLPath(r.URL.Path)))
+		defer span.End()
+
+		rec := &statusRecorder{ResponseWriter: w}
+		traced := r.WithContext(ctx)
+		next.ServeHTTP(rec, traced)
+		r.Pattern = traced.Pattern
+
+		status := rec.code(traced)
+		if traced.Pattern != "" {
+			span.SetName(traced.Pattern)
+			span.SetAttributes(semconv.HTTPRoute(traced.Pattern))
+		}
+		span.SetAttributes(semconv.HTTPResponseStatusCode(status))
+		if status >= 500 {
+			span.SetStatus(codes.Error, strconv.Itoa(status))
+		}
+	})
+}
+
+// traceBoardLookup annotates the current span with the board being served
+// and how the cache answered.
+func traceBoardLookup(ctx context.Context, stopID, cacheResult string) {
+	trace.SpanFromContext(ctx).SetAttributes(
+		attribute.String("rmv.stop_id", stopID),
+		attribute.String("rmv.cache", cacheResult),
+	)
+}
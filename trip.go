@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/url"
+)
+
+// tripList mirrors the trip JSON of the RMV HAFAS API. Only the fields
+// needed for TripResponse are typed.
+type tripList struct {
+	Trips []struct {
+		// Duration is an ISO 8601 duration such as "PT25M".
+		Duration string `json:"duration"`
+		LegList  struct {
+			Legs []struct {
+				// Type is JNY for rides, WALK or TRSF for walks between them.
+				Type        string   `json:"type"`
+				Name        string   `json:"name"`
+				Direction   string   `json:"direction"`
+				Origin      TripStop `json:"Origin"`
+				Destination TripStop `json:"Destination"`
+			} `json:"Leg"`
+		} `json:"LegList"`
+	} `json:"Trip"`
+	RequestID string `json:"requestId,omitempty"`
+}
+
+// TripStop is where a leg starts or ends. Times are local wall-clock times
+// like on Departure.
+type TripStop struct {
+	Name    string `json:"name"`
+	ID      string `json:"extId,omitempty"`
+	Date    string `json:"date"`
+	Time    string `json:"time"`
+	RtDate  string `json:"rtDate,omitempty"`
+	RtTime  string `json:"rtTime,omitempty"`
+	Track   string `json:"track,omitempty"`
+	RtTrack string `json:"rtTrack,omitempty"`
+}
+
+// TripResponse is the body of GET /trip: the connections RMV proposes,
+// earliest first.
+type TripResponse struct {
+	From      string `json:"from"`
+	To        string `json:"to"`
+	Trips     []Trip `json:"trips"`
+	RequestID string `json:"requestId,omitempty"`
+}
+
+// Trip is one connection from origin to destination.
+type Trip struct {
+	Duration string `json:"duration"`
+	// Transfers is the number of changes between rides.
+	Transfers int       `json:"transfers"`
+	Legs      []TripLeg `json:"legs"`
+}
+
+// TripLeg is a ride (type JNY) or a walk of a trip.
+type TripLeg struct {
+	Type string `json:"type"`
+	// Line and Direction are empty for walks.
+	Line        string   `json:"line,omitempty"`
+	Direction   string   `json:"direction,omitempty"`
+	Origin      TripStop `json:"origin"`
+	Destination TripStop `json:"destination"`
+}
+
+// Trips searches connections from one stop to another via the trip
+// service. date and time are local ("2006-01-02", "15:04"); empty means now.
+func (c *RMVClient) Trips(ctx context.Context, from, to, date, time string) (*TripResponse, error) {
+	params := url.Values{}
+	params.Set("originExtId", from)
+	params.Set("destExtId", to)
+	if date != "" {
+		params.Set("date", date)
+		params.Set("time", time)
+	}
+
+	var list tripList
+	if err := c.call(ctx, "trip", params, &list); err != nil {
+		return nil, err
+	}
+	resp := &TripResponse{From: from, To: to, Trips: make([]Trip, 0, len(list.Trips)), RequestID: list.RequestID}
+	for _, t := range list.Trips {
+		trip := Trip{Duration: t.Duration, Legs: make([]TripLeg, 0, len(t.LegList.Legs))}
+		rides := 0
+		for _, leg := range t.LegList.Legs {
+			l := TripLeg{Type: leg.Type, Origin: leg.Origin, Destination: leg.Destination}
+			if leg.Type == "JNY" {
+				rides++
+				l.Line = leg.Name
+				l.Direction = leg.Direction
+			}
+			trip.Legs = append(trip.Legs, l)
+		}
+		trip.Transfers = max(0, rides-1)
+		resp.Trips = append(resp.Trips, trip)
+	}
+	return resp, nil
+}
+
+// fetchTrips returns the connections for q, cached for the trip TTL.
+func fetchTrips(ctx context.Context, cache Cache, client *RMVClient, config Config, q tripQuery) (*TripResponse, error) {
+	cacheKey := "trip:" + q.From + ":" + q.To + ":" + q.Date + "T" + q.Time
+	if item, ok := cache.Get(ctx, cacheKey); ok {
+		var trips TripResponse
+		if err := json.Unmarshal(item.Data, &trips); err == nil {
+			cacheRequestsTotal.WithLabelValues("hit").Inc()
+			return &trips, nil
+		}
+	}
+	cacheRequestsTotal.WithLabelValues("miss").Inc()
+
+	trips, err := client.Trips(ctx, q.From, q.To, q.Date, q.Time)
+	if err != nil {
+		return nil, err
+	}
+	if data, err := json.Marshal(trips); err == nil {
+		cache.Set(ctx, cacheKey, data, config.CacheTTLs.For(ttlTrip))
+	}
+	slog.InfoContext(ctx, "fetched trips", "from", q.From, "to", q.To, "count", len(trips.Trips))
+	return trips, nil
+}
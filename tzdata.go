@@ -0,0 +1,7 @@
+//go:build tzdata
+
+package main
+
+// Building with -tags tzdata embeds the timezone database (about 450 KB), so
+// TIMEZONE works in images without /usr/share/zoneinfo.
+import _ "time/tzdata"
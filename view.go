@@ -0,0 +1,83 @@
+package main
+
+import (
+	"slices"
+	"strings"
+)
+
+// Transformer turns a processed board into the response body of a view.
+// Views only reshape data; fetching, filtering and sorting happen before.
+type Transformer interface {
+	Transform(boardType BoardType, stopID string, board *DepartureBoard) any
+}
+
+// TransformerFunc adapts a function to a Transformer.
+type TransformerFunc func(boardType BoardType, stopID string, board *DepartureBoard) any
+
+func (f TransformerFunc) Transform(boardType BoardType, stopID string, board *DepartureBoard) any {
+	return f(boardType, stopID, board)
+}
+
+// defaultView is used when the request has no ?view=.
+const defaultView = "full"
+
+// views maps the names accepted by ?view= to their transformers.
+var views = map[string]Transformer{
+	"full":    TransformerFunc(newBoardResponse),
+	"compact": TransformerFunc(compactBoardResponse),
+}
+
+func viewNames() []string {
+	names := make([]string, 0, len(views))
+	for name := range views {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// parseView looks up the transformer for a ?view= value.
+func parseView(raw string) (Transformer, *requestError) {
+	if raw == "" {
+		raw = defaultView
+	}
+	view, ok := views[strings.ToLower(raw)]
+	if !ok {
+		return nil, badParameter("view must be one of %s", strings.Join(viewNames(), ", "))
+	}
+	return view, nil
+}
+
+// compactEntry is a board entry of the compact view. Destination is set on
+// departures, Origin on arrivals.
+type compactEntry struct {
+	Line         string `json:"line"`
+	Destination  string `json:"destination,omitempty"`
+	Origin       string `json:"origin,omitempty"`
+	MinutesUntil int    `json:"minutesUntil"`
+}
+
+type compactDeparturesResponse struct {
+	StopID     string         `json:"stopId"`
+	Departures []compactEntry `json:"departures"`
+	boardMeta
+}
+
+type compactArrivalsResponse struct {
+	StopID   string         `json:"stopId"`
+	Arrivals []compactEntry `json:"arrivals"`
+	boardMeta
+}
+
+// compactBoardResponse is the compact view: line, destination and minutes
+// until departure only, for small displays.
+func compactBoardResponse(boardType BoardType, stopID string, board *DepartureBoard) any {
+	entries := make([]compactEntry, 0, len(board.entries()))
+	for _, d := range board.entries() {
+		entries = append(entries, compactEntry{Line: d.line(), Destination: d.Direction, Origin: d.Origin, MinutesUntil: d.MinutesUntil})
+	}
+	if boardType == BoardArrivals {
+		return compactArrivalsResponse{StopID: stopID, Arrivals: entries, boardMeta: board.Meta}
+	}
+	return compactDeparturesResponse{StopID: stopID, Departures: entries, boardMeta: board.Meta}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/xml"
+	"time"
+)
+
+// xmlBoard is the XML form of a board:
+//
+//	<departures stopId="...">
+//	  <departure line="12" direction="Hbf" scheduled="..." minutesUntil="5"/>
+//	</departures>
+//
+// Arrival boards use <arrivals> and <arrival>. The attributes are the CSV
+// columns plus minutesUntil; empty ones are omitted.
+type xmlBoard struct {
+	XMLName xml.Name
+	StopID  string     `xml:"stopId,attr"`
+	Entries []xmlEntry `xml:""`
+}
+
+type xmlEntry struct {
+	XMLName      xml.Name
+	Line         string `xml:"line,attr"`
+	Direction    string `xml:"direction,attr,omitempty"`
+	Scheduled    string `xml:"scheduled,attr,omitempty"`
+	Realtime     string `xml:"realtime,attr,omitempty"`
+	Delay        string `xml:"delay,attr,omitempty"`
+	Platform     string `xml:"platform,attr,omitempty"`
+	MinutesUntil int    `xml:"minutesUntil,attr"`
+}
+
+// boardXML renders the entries of board as XML, see xmlBoard.
+func boardXML(boardType BoardType, stopID string, board *DepartureBoard, loc *time.Location) ([]byte, error) {
+	root, entry := "departures", "departure"
+	if boardType == BoardArrivals {
+		root, entry = "arrivals", "arrival"
+	}
+	doc := xmlBoard{XMLName: xml.Name{Local: root}, StopID: stopID, Entries: make([]xmlEntry, 0, len(board.entries()))}
+	for _, d := range board.entries() {
+		row := newBoardRow(d, loc)
+		doc.Entries = append(doc.Entries, xmlEntry{
+			XMLName:      xml.Name{Local: entry},
+			Line:         row.Line,
+			Direction:    row.Direction,
+			Scheduled:    row.Scheduled,
+			Realtime:     row.Realtime,
+			Delay:        row.Delay,
+			Platform:     row.Platform,
+			MinutesUntil: d.MinutesUntil,
+		})
+	}
+	body, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), append(body, '\n')...), nil
+}